@@ -0,0 +1,45 @@
+// Package fields converts the fields of other logging libraries into
+// github.com/apex/log.Fields that can be stored by apexorc, for the
+// adapters in the packages alongside it.
+package fields
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/apex/log"
+)
+
+// OriginalLevel is the field that records the level an entry was
+// logged at by the other library, when it has no exact apex
+// equivalent.
+const OriginalLevel = "original_level"
+
+// Add adds v to f under key.  Maps are flattened, with the key of each
+// of their values joined to key by a dot.  Everything else keeps its
+// type, which apexorc records along with the value, except that JSON
+// numbers, as decoded from zerolog's output, become int64s or, if
+// they aren't whole, float64s.
+func Add(f log.Fields, key string, v interface{}) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			Add(f, key+"."+k, x[k])
+		}
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			f[key] = n
+		} else if n, err := x.Float64(); err == nil {
+			f[key] = n
+		} else {
+			f[key] = x.String()
+		}
+	default:
+		f[key] = v
+	}
+}
@@ -0,0 +1,83 @@
+// Package logrusorc provides a github.com/sirupsen/logrus Hook that
+// passes entries on to a github.com/apex/log.Handler, such as an
+// apexorc.RotatingHandler, so that logrus loggers write to the same
+// ORC files as apex loggers.
+package logrusorc
+
+import (
+	"strconv"
+
+	"github.com/apex/log"
+	"github.com/sirupsen/logrus"
+
+	"github.com/avct/apexorc/adapters/internal/fields"
+)
+
+// The fields that hold the caller of an entry, when the logger
+// reports it, and the logrus level of entries logged at levels that
+// apex doesn't have.
+const (
+	CallerField        = "caller"
+	FunctionField      = "func"
+	OriginalLevelField = fields.OriginalLevel
+)
+
+// Hook is a logrus.Hook writing to a log.Handler.  Data is stored
+// under the same keys, keeping its types, with maps flattened into
+// dotted keys; logrus' "error" key matches the field apex uses for
+// errors.  TraceLevel entries are
+// written at log.DebugLevel and PanicLevel entries at log.FatalLevel,
+// with the logrus level in OriginalLevelField.
+type Hook struct {
+	handler log.Handler
+	levels  []logrus.Level
+}
+
+// NewHook returns a Hook passing entries at levels on to handler.  If
+// no levels are given, every level is passed on.
+func NewHook(handler log.Handler, levels ...logrus.Level) *Hook {
+	if len(levels) == 0 {
+		levels = logrus.AllLevels
+	}
+	return &Hook{handler: handler, levels: levels}
+}
+
+// Levels returns the levels the Hook fires for.
+func (h *Hook) Levels() []logrus.Level {
+	return h.levels
+}
+
+// Fire passes entry on to the handler.
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	e := &log.Entry{
+		Timestamp: entry.Time,
+		Message:   entry.Message,
+		Fields:    make(log.Fields, len(entry.Data)+2),
+	}
+	for k, v := range entry.Data {
+		fields.Add(e.Fields, k, v)
+	}
+	switch entry.Level {
+	case logrus.TraceLevel:
+		e.Level = log.DebugLevel
+		e.Fields[OriginalLevelField] = entry.Level.String()
+	case logrus.DebugLevel:
+		e.Level = log.DebugLevel
+	case logrus.InfoLevel:
+		e.Level = log.InfoLevel
+	case logrus.WarnLevel:
+		e.Level = log.WarnLevel
+	case logrus.ErrorLevel:
+		e.Level = log.ErrorLevel
+	case logrus.FatalLevel:
+		e.Level = log.FatalLevel
+	default:
+		e.Level = log.FatalLevel
+		e.Fields[OriginalLevelField] = entry.Level.String()
+	}
+	if entry.HasCaller() {
+		e.Fields[CallerField] = entry.Caller.File + ":" + strconv.Itoa(entry.Caller.Line)
+		e.Fields[FunctionField] = entry.Caller.Function
+	}
+	return h.handler.HandleLog(e)
+}
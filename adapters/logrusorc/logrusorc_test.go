@@ -0,0 +1,51 @@
+package logrusorc
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/sirupsen/logrus"
+)
+
+type recorder struct {
+	entries []*log.Entry
+}
+
+func (r *recorder) HandleLog(e *log.Entry) error {
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+func TestHook(t *testing.T) {
+	rec := &recorder{}
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	logger.SetLevel(logrus.TraceLevel)
+	logger.AddHook(NewHook(rec))
+	timeout := errors.New("timeout")
+
+	logger.WithFields(logrus.Fields{
+		"status": 200,
+		"user":   map[string]interface{}{"id": 42},
+	}).WithError(timeout).Warn("Slow")
+	logger.Trace("Detail")
+
+	if len(rec.entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(rec.entries))
+	}
+	e := rec.entries[0]
+	if e.Level != log.WarnLevel || e.Message != "Slow" || e.Timestamp.IsZero() {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	expected := log.Fields{"status": 200, "user.id": 42, "error": timeout}
+	if !reflect.DeepEqual(e.Fields, expected) {
+		t.Errorf("Expected fields %v, got %v", expected, e.Fields)
+	}
+	e = rec.entries[1]
+	if e.Level != log.DebugLevel || e.Fields[OriginalLevelField] != "trace" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+}
@@ -0,0 +1,109 @@
+// Package zaporc provides a go.uber.org/zap Core that passes entries
+// on to a github.com/apex/log.Handler, such as an
+// apexorc.RotatingHandler, so that zap loggers write to the same ORC
+// files as apex loggers.
+package zaporc
+
+import (
+	"github.com/apex/log"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/avct/apexorc/adapters/internal/fields"
+)
+
+// The fields that hold the parts of a zap entry that have no column
+// of their own, and the zap level of entries logged at levels that
+// apex doesn't have.
+const (
+	LoggerField        = "logger"
+	CallerField        = "caller"
+	StackField         = "stacktrace"
+	OriginalLevelField = fields.OriginalLevel
+)
+
+// Core is a zapcore.Core writing to a log.Handler.  Fields keep the
+// types zap's MapObjectEncoder gives them, so integers, floats, bools,
+// times and durations are stored as such; namespaces and objects are
+// flattened, with their keys joined by dots.  DPanicLevel and PanicLevel entries are written at
+// log.ErrorLevel and log.FatalLevel respectively, with the zap level
+// in OriginalLevelField.
+type Core struct {
+	zapcore.LevelEnabler
+	handler log.Handler
+	fields  []zapcore.Field
+}
+
+// NewCore returns a Core passing entries at levels enabled by enab on
+// to handler.
+func NewCore(handler log.Handler, enab zapcore.LevelEnabler) *Core {
+	return &Core{LevelEnabler: enab, handler: handler}
+}
+
+// With returns a Core that adds fields to every entry.
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	return &clone
+}
+
+// Check adds the Core to ce if ent's level is enabled.
+func (c *Core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write passes ent, with fields, on to the handler.
+func (c *Core) Write(ent zapcore.Entry, fs []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fs {
+		f.AddTo(enc)
+	}
+
+	e := &log.Entry{
+		Timestamp: ent.Time,
+		Message:   ent.Message,
+		Fields:    make(log.Fields, len(enc.Fields)+3),
+	}
+	for k, v := range enc.Fields {
+		fields.Add(e.Fields, k, v)
+	}
+	switch ent.Level {
+	case zapcore.DebugLevel:
+		e.Level = log.DebugLevel
+	case zapcore.InfoLevel:
+		e.Level = log.InfoLevel
+	case zapcore.WarnLevel:
+		e.Level = log.WarnLevel
+	case zapcore.ErrorLevel:
+		e.Level = log.ErrorLevel
+	case zapcore.FatalLevel:
+		e.Level = log.FatalLevel
+	case zapcore.DPanicLevel:
+		e.Level = log.ErrorLevel
+		e.Fields[OriginalLevelField] = ent.Level.String()
+	default:
+		e.Level = log.FatalLevel
+		e.Fields[OriginalLevelField] = ent.Level.String()
+	}
+	if ent.LoggerName != "" {
+		e.Fields[LoggerField] = ent.LoggerName
+	}
+	if ent.Caller.Defined {
+		e.Fields[CallerField] = ent.Caller.TrimmedPath()
+	}
+	if ent.Stack != "" {
+		e.Fields[StackField] = ent.Stack
+	}
+	return c.handler.HandleLog(e)
+}
+
+// Sync does nothing, as entries are handed to the handler as they
+// are written.  Rotate or close the handler to make them durable.
+func (c *Core) Sync() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package zaporc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type recorder struct {
+	entries []*log.Entry
+}
+
+func (r *recorder) HandleLog(e *log.Entry) error {
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+func TestCore(t *testing.T) {
+	rec := &recorder{}
+	logger := zap.New(NewCore(rec, zapcore.InfoLevel)).Named("api")
+
+	logger.Debug("Dropped")
+	logger.With(zap.String("service", "api")).Warn("Slow",
+		zap.Int("status", 200),
+		zap.Duration("elapsed", 1500*time.Millisecond),
+		zap.Error(errors.New("timeout")),
+		zap.Namespace("request"),
+		zap.String("method", "GET"),
+	)
+	logger.DPanic("Odd")
+
+	if len(rec.entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(rec.entries))
+	}
+	e := rec.entries[0]
+	if e.Level != log.WarnLevel || e.Message != "Slow" || e.Timestamp.IsZero() {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	expected := log.Fields{
+		"service":        "api",
+		"status":         int64(200),
+		"elapsed":        1500 * time.Millisecond,
+		"error":          "timeout",
+		"request.method": "GET",
+		LoggerField:      "api",
+	}
+	if !reflect.DeepEqual(e.Fields, expected) {
+		t.Errorf("Expected fields %v, got %v", expected, e.Fields)
+	}
+	e = rec.entries[1]
+	if e.Level != log.ErrorLevel || e.Fields[OriginalLevelField] != "dpanic" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+}
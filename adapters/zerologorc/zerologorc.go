@@ -0,0 +1,151 @@
+// Package zerologorc provides an io.Writer for github.com/rs/zerolog
+// loggers that passes their entries on to a github.com/apex/log.Handler,
+// such as an apexorc.RotatingHandler, so that zerolog loggers write
+// to the same ORC files as apex loggers.
+package zerologorc
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/rs/zerolog"
+
+	"github.com/avct/apexorc/adapters/internal/fields"
+)
+
+// OriginalLevelField holds the zerolog level of entries logged at
+// levels that apex doesn't have.
+const OriginalLevelField = fields.OriginalLevel
+
+// Writer is a zerolog.LevelWriter that decodes the JSON entries
+// written by a zerolog.Logger and passes them on to a log.Handler.
+// The timestamp, level and message are read from the fields named by
+// zerolog's TimestampFieldName, LevelFieldName and MessageFieldName,
+// and the timestamp is parsed according to TimeFieldFormat; entries
+// without a timestamp are given the time they were written.  Errors
+// are stored in the "error" field used by apex, whatever
+// ErrorFieldName is.  Other fields are stored under the same keys, as
+// decoded from JSON, with numbers as int64s or float64s and
+// dictionaries flattened into dotted keys.
+//
+// TraceLevel entries are written at log.DebugLevel, PanicLevel
+// entries at log.FatalLevel and NoLevel entries at log.InfoLevel,
+// with the zerolog level in OriginalLevelField.
+type Writer struct {
+	handler log.Handler
+}
+
+// NewWriter returns a Writer passing entries on to handler.  Use it
+// as the output of a logger:
+//
+//	logger := zerolog.New(zerologorc.NewWriter(rotator)).With().Timestamp().Logger()
+func NewWriter(handler log.Handler) *Writer {
+	return &Writer{handler: handler}
+}
+
+// Write decodes the entry in p and passes it on, taking its level
+// from the entry itself.
+func (w *Writer) Write(p []byte) (int, error) {
+	level := zerolog.NoLevel
+	var head map[string]json.RawMessage
+	if json.Unmarshal(p, &head) == nil {
+		var name string
+		if json.Unmarshal(head[zerolog.LevelFieldName], &name) == nil {
+			if l, err := zerolog.ParseLevel(name); err == nil {
+				level = l
+			}
+		}
+	}
+	return w.WriteLevel(level, p)
+}
+
+// WriteLevel decodes the entry in p, logged at level, and passes it
+// on.
+func (w *Writer) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	var data map[string]interface{}
+	err := dec.Decode(&data)
+	if err != nil {
+		return 0, err
+	}
+
+	e := &log.Entry{Fields: make(log.Fields, len(data))}
+	e.Timestamp = parseTime(data[zerolog.TimestampFieldName])
+	if msg, ok := data[zerolog.MessageFieldName].(string); ok {
+		e.Message = msg
+	}
+	for k, v := range data {
+		switch k {
+		case zerolog.TimestampFieldName, zerolog.LevelFieldName, zerolog.MessageFieldName:
+			continue
+		case zerolog.ErrorFieldName:
+			k = "error"
+		}
+		fields.Add(e.Fields, k, v)
+	}
+	switch level {
+	case zerolog.DebugLevel:
+		e.Level = log.DebugLevel
+	case zerolog.InfoLevel:
+		e.Level = log.InfoLevel
+	case zerolog.WarnLevel:
+		e.Level = log.WarnLevel
+	case zerolog.ErrorLevel:
+		e.Level = log.ErrorLevel
+	case zerolog.FatalLevel:
+		e.Level = log.FatalLevel
+	case zerolog.TraceLevel:
+		e.Level = log.DebugLevel
+		e.Fields[OriginalLevelField] = level.String()
+	case zerolog.PanicLevel:
+		e.Level = log.FatalLevel
+		e.Fields[OriginalLevelField] = level.String()
+	default:
+		e.Level = log.InfoLevel
+		e.Fields[OriginalLevelField] = level.String()
+	}
+
+	err = w.handler.HandleLog(e)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// parseTime parses a timestamp written according to
+// zerolog.TimeFieldFormat, returning the current time if there is
+// none.
+func parseTime(v interface{}) time.Time {
+	switch t := v.(type) {
+	case string:
+		if ts, err := time.Parse(zerolog.TimeFieldFormat, t); err == nil {
+			return ts
+		}
+		if ts, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return ts
+		}
+	case json.Number:
+		n, err := strconv.ParseInt(t.String(), 10, 64)
+		if err != nil {
+			f, ferr := t.Float64()
+			if ferr != nil {
+				break
+			}
+			return time.Unix(0, int64(f*float64(time.Second)))
+		}
+		switch zerolog.TimeFieldFormat {
+		case zerolog.TimeFormatUnixMs:
+			return time.UnixMilli(n)
+		case zerolog.TimeFormatUnixMicro:
+			return time.UnixMicro(n)
+		case zerolog.TimeFormatUnixNano:
+			return time.Unix(0, n)
+		}
+		return time.Unix(n, 0)
+	}
+	return time.Now()
+}
@@ -0,0 +1,58 @@
+package zerologorc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/rs/zerolog"
+)
+
+type recorder struct {
+	entries []*log.Entry
+}
+
+func (r *recorder) HandleLog(e *log.Entry) error {
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+func TestWriter(t *testing.T) {
+	rec := &recorder{}
+	logger := zerolog.New(NewWriter(rec)).With().Timestamp().Str("service", "api").Logger()
+
+	logger.Warn().
+		Int("status", 200).
+		Float64("ratio", 0.5).
+		Err(errors.New("timeout")).
+		Dict("request", zerolog.Dict().Str("method", "GET")).
+		Msg("Slow")
+	logger.Trace().Msg("Detail")
+
+	if len(rec.entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(rec.entries))
+	}
+	e := rec.entries[0]
+	if e.Level != log.WarnLevel || e.Message != "Slow" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	if time.Since(e.Timestamp) > time.Minute {
+		t.Errorf("Unexpected timestamp %v", e.Timestamp)
+	}
+	expected := log.Fields{
+		"service":        "api",
+		"status":         int64(200),
+		"ratio":          0.5,
+		"error":          "timeout",
+		"request.method": "GET",
+	}
+	if !reflect.DeepEqual(e.Fields, expected) {
+		t.Errorf("Expected fields %v, got %v", expected, e.Fields)
+	}
+	e = rec.entries[1]
+	if e.Level != log.DebugLevel || e.Fields[OriginalLevelField] != "trace" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/avct/apexorc"
+)
+
+func convert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	out := flags.String("o", "", "write a single ORC file to `path`, rather than one alongside each input")
+	keys := apexorc.DefaultKeyMapping
+	flags.StringVar(&keys.Timestamp, "timestamp", keys.Timestamp, "the `key` holding each entry's timestamp")
+	flags.StringVar(&keys.Level, "level", keys.Level, "the `key` holding each entry's level")
+	flags.StringVar(&keys.Message, "message", keys.Message, "the `key` holding each entry's message")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: apexorc convert [flags] [JSON-lines file ...]\n\n")
+		fmt.Fprintf(os.Stderr, "Converts JSON-lines logs, which may be gzip compressed, to ORC.  With no\n")
+		fmt.Fprintf(os.Stderr, "files, or a file of -, standard input is read and -o is required.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	inputs := flags.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+	if *out != "" {
+		return convertInputs(inputs, *out, keys)
+	}
+	for _, input := range inputs {
+		if input == "-" {
+			return errors.New("-o is required when reading standard input")
+		}
+	}
+	for _, input := range inputs {
+		err := convertInputs([]string{input}, orcPathForInput(input), keys)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertInputs converts the concatenation of inputs to a single ORC
+// file at orcPath.
+func convertInputs(inputs []string, orcPath string, keys apexorc.KeyMapping) error {
+	readers := make([]io.Reader, 0, len(inputs))
+	for _, input := range inputs {
+		if input == "-" {
+			readers = append(readers, os.Stdin)
+			continue
+		}
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	rows, err := apexorc.ConvertJSONLines(orcPath, keys, readers...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: %d entries\n", orcPath, rows)
+	return nil
+}
+
+// orcPathForInput returns the path of the ORC file that input is
+// converted to, alongside it with its extensions replaced.
+func orcPathForInput(input string) string {
+	base := strings.TrimSuffix(input, ".gz")
+	return strings.TrimSuffix(base, filepath.Ext(base)) + ".orc"
+}
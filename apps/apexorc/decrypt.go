@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/avct/apexorc"
+)
+
+func decrypt(args []string) error {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyRing := flags.String("keys", "", "read keys from the key ring at `path` (required)")
+	out := flags.String("o", "", "write the plaintext to `path`, rather than standard output")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: apexorc decrypt -keys <key ring> [flags] <file>\n\n")
+		fmt.Fprintf(os.Stderr, "Decrypts a journal or archive written by a RotatingHandler with\n")
+		fmt.Fprintf(os.Stderr, "encryption enabled.  The key ring is a JSON file of the form\n")
+		fmt.Fprintf(os.Stderr, "{\"current\": \"<id>\", \"keys\": {\"<id>\": \"<base64 key>\"}}.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || *keyRing == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	keys, err := loadKeys(*keyRing)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return apexorc.DecryptFile(os.Stdout, flags.Arg(0), keys)
+	}
+
+	// Write alongside the output first, so we never leave half a
+	// file behind.
+	tmp, err := ioutil.TempFile(filepath.Dir(*out), ".apexorc-decrypt-")
+	if err != nil {
+		return err
+	}
+	err = apexorc.DecryptFile(tmp, flags.Arg(0), keys)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), *out)
+}
+
+// loadKeys loads the key ring at path, if there is one.
+func loadKeys(path string) (apexorc.KeyProvider, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return apexorc.LoadKeyRing(path)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/avct/apexorc"
+)
+
+func follow(args []string) error {
+	flags := flag.NewFlagSet("follow", flag.ExitOnError)
+	backlog := flags.Int("n", 10, "start with the last `N` entries logged")
+	asJSON := flags.Bool("json", false, "print entries as JSON lines")
+	keyRing := flags.String("keys", "", "decrypt an encrypted log with the key ring at `path`")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: apexorc follow [flags] <path to ORC log>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	keys, err := loadKeys(*keyRing)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = apexorc.FollowEncrypted(ctx, flags.Arg(0), keys, *backlog, newOutputHandler(os.Stdout, *asJSON))
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
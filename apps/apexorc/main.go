@@ -0,0 +1,56 @@
+// Command apexorc works with the logs written by the
+// github.com/avct/apexorc package.
+//
+// Usage:
+//
+//	apexorc <command> [flags] [arguments]
+//
+// Run "apexorc <command> -h" for the flags of each command.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// A command is one of the subcommands of apexorc.
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"convert":   {"convert JSON-lines logs to ORC", convert},
+	"decrypt":   {"decrypt encrypted journals and archives", decrypt},
+	"follow":    {"print entries as they are logged, across rotations", follow},
+	"transcode": {"transcode ORC archives to Parquet", transcode},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: apexorc <command> [flags] [arguments]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	err := cmd.run(os.Args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "apexorc %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
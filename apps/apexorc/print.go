@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/json"
+)
+
+// printHandler writes entries to a terminal, one per line.
+type printHandler struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// newOutputHandler returns a log.Handler that writes entries to w,
+// as JSON lines if asJSON is true.
+func newOutputHandler(w io.Writer, asJSON bool) log.Handler {
+	if asJSON {
+		return json.New(w)
+	}
+	return &printHandler{w: w}
+}
+
+func (h *printHandler) HandleLog(e *log.Entry) error {
+	names := e.Fields.Names()
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %s", e.Timestamp.Format(time.RFC3339Nano), strings.ToUpper(e.Level.String()), e.Message)
+	for _, name := range names {
+		fmt.Fprintf(&b, " %s=%v", name, e.Fields.Get(name))
+	}
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
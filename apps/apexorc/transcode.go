@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/avct/apexorc"
+)
+
+func transcode(args []string) error {
+	flags := flag.NewFlagSet("transcode", flag.ExitOnError)
+	out := flags.String("o", "", "write the Parquet file to `path`, rather than alongside a single input")
+	keyRing := flags.String("keys", "", "decrypt encrypted ORC files with the key ring at `path`")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: apexorc transcode [flags] <ORC file> ...\n\n")
+		fmt.Fprintf(os.Stderr, "Transcodes ORC files written by apexorc to Parquet.  Without -o, each\n")
+		fmt.Fprintf(os.Stderr, "Parquet file is written alongside its ORC file, with its .orc extension\n")
+		fmt.Fprintf(os.Stderr, "replaced by .parquet.  With -keys, the Parquet files are encrypted with\n")
+		fmt.Fprintf(os.Stderr, "the key ring's current key; use apexorc decrypt to read them.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 || (*out != "" && flags.NArg() != 1) {
+		flags.Usage()
+		os.Exit(2)
+	}
+	keys, err := loadKeys(*keyRing)
+	if err != nil {
+		return err
+	}
+
+	for _, orcPath := range flags.Args() {
+		parquetPath := *out
+		if parquetPath == "" {
+			parquetPath = apexorc.ParquetPath(orcPath)
+		}
+		rows, err := apexorc.TranscodeEncryptedORCToParquet(orcPath, parquetPath, keys)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d entries\n", parquetPath, rows)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package apexorc
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scritchley/orc"
+)
+
+// catalogSuffix is appended to the path of the live ORC file to give
+// the path of the catalog of its archives.
+const catalogSuffix = ".catalog.json"
+
+// Archive describes an archived ORC log file.  Manifest is nil if the
+// archive has no manifest, in which case the time range was found by
+// reading the file itself.
+type Archive struct {
+	Path         string    `json:"path"`
+	MinTimestamp time.Time `json:"min_timestamp"`
+	MaxTimestamp time.Time `json:"max_timestamp"`
+	Rows         int64     `json:"rows"`
+	Manifest     *Manifest `json:"manifest,omitempty"`
+}
+
+// Covers returns true if any entry in the archive might fall between
+// from and to, inclusive.  A zero from or to leaves that end of the
+// range open.
+func (a Archive) Covers(from, to time.Time) bool {
+	if !from.IsZero() && a.MaxTimestamp.Before(from) {
+		return false
+	}
+	if !to.IsZero() && a.MinTimestamp.After(to) {
+		return false
+	}
+	return true
+}
+
+// catalog is the form in which the archives of a log are persisted at
+// CatalogPath.  Archive paths are relative to the catalog's directory.
+type catalog struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	Archives  []Archive `json:"archives"`
+}
+
+// CatalogPath returns the path of the catalog of the archives of the
+// ORC log at path.  The catalog is a JSON document, rewritten by a
+// RotatingHandler each time it archives a file, listing the same
+// archives as ListArchives, for the benefit of tools that can't call
+// it.
+func CatalogPath(path string) string {
+	return path + catalogSuffix
+}
+
+// ListArchives returns the archives of the ORC log at path, as named
+// by NumericArchiveF, oldest first.  The time range of each archive is
+// taken from its manifest where there is one.
+func ListArchives(path string) ([]Archive, error) {
+	return listArchives(OSFS{}, path)
+}
+
+// ListArchivesFS is like ListArchives, but lists the archives of a log
+// kept on fsys.
+func ListArchivesFS(fsys FS, path string) ([]Archive, error) {
+	return listArchives(fsys, path)
+}
+
+func listArchives(fsys FS, path string) ([]Archive, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	infos, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	suffixes := make(map[string]int)
+	var names []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		n, err := strconv.Atoi(name[len(base)+1:])
+		if err != nil || n < 1 {
+			continue
+		}
+		suffixes[name] = n
+		names = append(names, name)
+	}
+	// The higher the suffix, the older the archive.
+	sort.Slice(names, func(i, j int) bool {
+		return suffixes[names[i]] > suffixes[names[j]]
+	})
+
+	archives := make([]Archive, 0, len(names))
+	for _, name := range names {
+		a, err := describeArchive(fsys, filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			// It was archived again while we were looking.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, a)
+	}
+	return archives, nil
+}
+
+// describeArchive returns the Archive for the ORC file at orcPath.
+func describeArchive(fsys FS, orcPath string) (Archive, error) {
+	a := Archive{Path: orcPath}
+	m, err := readManifest(fsys, orcPath)
+	if err == nil {
+		a.MinTimestamp = m.MinTimestamp
+		a.MaxTimestamp = m.MaxTimestamp
+		a.Rows = m.Rows
+		a.Manifest = m
+		return a, nil
+	}
+	if !os.IsNotExist(err) {
+		return a, err
+	}
+
+	stats, err := scanORCTimestamps(fsys, orcPath)
+	if err != nil {
+		return a, err
+	}
+	a.MinTimestamp = stats.min
+	a.MaxTimestamp = stats.max
+	a.Rows = stats.rows
+	return a, nil
+}
+
+// scanORCTimestamps reads the timestamp column of the ORC file at
+// orcPath, for files that have no manifest.
+func scanORCTimestamps(fsys FS, orcPath string) (entryStats, error) {
+	var stats entryStats
+	f, err := fsys.Open(orcPath)
+	if err != nil {
+		return stats, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return stats, err
+	}
+	r, err := orc.NewReader(sizedFile{f, info.Size()})
+	if err != nil {
+		return stats, err
+	}
+	defer r.Close()
+	cursor := r.Select("timestamp")
+	for cursor.Stripes() {
+		for cursor.Next() {
+			ts, ok := cursor.Row()[0].(time.Time)
+			if !ok {
+				continue
+			}
+			stats.rows++
+			if stats.min.IsZero() || ts.Before(stats.min) {
+				stats.min = ts
+			}
+			if ts.After(stats.max) {
+				stats.max = ts
+			}
+		}
+	}
+	return stats, cursor.Err()
+}
+
+// updateCatalog rewrites the catalog of the archives of the ORC log at
+// path.
+func updateCatalog(fsys FS, path string) error {
+	archives, err := listArchives(fsys, path)
+	if err != nil {
+		return err
+	}
+	for i := range archives {
+		archives[i].Path = filepath.Base(archives[i].Path)
+	}
+	b, err := json.MarshalIndent(catalog{UpdatedAt: time.Now(), Archives: archives}, "", "  ")
+	if err != nil {
+		return err
+	}
+	catalogPath := CatalogPath(path)
+	tmpPath := makeTempPathFromPath(catalogPath)
+	err = writeFile(fsys, tmpPath, b, 0644)
+	if err != nil {
+		return err
+	}
+	return fsys.Rename(tmpPath, catalogPath)
+}
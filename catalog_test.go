@@ -0,0 +1,93 @@
+package apexorc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+func TestListArchives(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-catalog")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, NumericArchiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	log.SetHandler(rotator)
+	for _, msg := range []string{"First", "Second", "Third"} {
+		log.Info(msg)
+		err = rotator.Rotate()
+		if err != nil {
+			t.Fatalf("Error rotating: %s", err)
+		}
+		// Keep the archives' time ranges apart.
+		time.Sleep(2 * time.Millisecond)
+	}
+	// An archive without a manifest should still be listed.
+	err = os.Remove(ManifestPath(path + ".3"))
+	if err != nil {
+		t.Fatalf("Error removing manifest: %s", err)
+	}
+
+	archives, err := ListArchives(path)
+	if err != nil {
+		t.Fatalf("Error listing archives: %s", err)
+	}
+	if len(archives) != 3 {
+		t.Fatalf("Expected 3 archives, got %d", len(archives))
+	}
+	for i, suffix := range []string{".3", ".2", ".1"} {
+		a := archives[i]
+		if a.Path != path+suffix {
+			t.Errorf("[Archive %d] Expected path %q, got %q", i, path+suffix, a.Path)
+		}
+		if a.Rows != 1 {
+			t.Errorf("[Archive %d] Expected 1 row, got %d", i, a.Rows)
+		}
+		if a.MinTimestamp.IsZero() || !a.MinTimestamp.Equal(a.MaxTimestamp) {
+			t.Errorf("[Archive %d] Unexpected time range %v - %v", i, a.MinTimestamp, a.MaxTimestamp)
+		}
+		if i > 0 && !archives[i-1].MaxTimestamp.Before(a.MinTimestamp) {
+			t.Errorf("[Archive %d] Archives are not in chronological order", i)
+		}
+	}
+	if archives[0].Manifest != nil {
+		t.Errorf("Expected no manifest for the oldest archive")
+	}
+	if archives[2].Manifest == nil {
+		t.Errorf("Expected a manifest for the newest archive")
+	}
+
+	if archives[1].Covers(archives[2].MinTimestamp, time.Time{}) {
+		t.Errorf("Expected the middle archive not to cover the newest one's range")
+	}
+	if !archives[1].Covers(time.Time{}, archives[1].MinTimestamp) {
+		t.Errorf("Expected the middle archive to cover its own start")
+	}
+
+	b, err := ioutil.ReadFile(CatalogPath(path))
+	if err != nil {
+		t.Fatalf("Error reading catalog: %s", err)
+	}
+	var c catalog
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		t.Fatalf("Error decoding catalog: %s", err)
+	}
+	if len(c.Archives) != 3 {
+		t.Fatalf("Expected 3 archives in the catalog, got %d", len(c.Archives))
+	}
+	if c.Archives[0].Path != "testlog.orc.3" {
+		t.Errorf("Expected the oldest archive first in the catalog, got %q", c.Archives[0].Path)
+	}
+}
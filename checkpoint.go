@@ -0,0 +1,193 @@
+package apexorc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/apex/log"
+)
+
+// checkpointName is the name of the file, alongside a rotated
+// journal, that records how much of it has been converted.
+const checkpointName = "checkpoint.json"
+
+// ChunkedConversion is an Option that makes the RotatingHandler
+// convert each journal in chunks of at most rows entries, for the
+// sake of very large journals.  Each chunk is archived as a file of
+// its own, with its own Manifest, as soon as it has been written, and
+// a checkpoint of the journal offset it ends at is kept alongside the
+// journal.  Should the process die, or the conversion be cancelled or
+// fail, the next attempt carries on from the last checkpoint rather
+// than starting again, and no entry is ever archived twice.
+func ChunkedConversion(rows int) Option {
+	return func(h *RotatingHandler) {
+		h.chunkRows = rows
+	}
+}
+
+// conversionCheckpoint records how far through a journal conversion
+// has got.  Everything before Offset has been archived.  Pending is
+// set once the chunk ending at that offset has been written, while it
+// is being archived, as it can't be known whether the ArchiveFunc
+// finished with it.  StagingID is the StagingID written to the
+// manifests of the chunks, which identifies a chunk left part way
+// through archiving.
+type conversionCheckpoint struct {
+	StagingID string `json:"staging_id"`
+	Offset    int64  `json:"offset"`
+	Pending   *int64 `json:"pending,omitempty"`
+}
+
+// readCheckpoint reads the checkpoint kept in dir, returning nil if
+// there isn't one.
+func readCheckpoint(fsys FS, dir string) (*conversionCheckpoint, error) {
+	b, err := readFile(fsys, filepath.Join(dir, checkpointName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := &conversionCheckpoint{}
+	err = json.Unmarshal(b, cp)
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// writeCheckpoint replaces the checkpoint kept in dir.  It is written
+// to a temporary file first, so it is never seen partially written.
+func writeCheckpoint(fsys FS, dir string, cp *conversionCheckpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, checkpointName)
+	tmpPath := makeTempPathFromPath(path)
+	err = writeFile(fsys, tmpPath, b, 0600)
+	if err != nil {
+		return err
+	}
+	return fsys.Rename(tmpPath, path)
+}
+
+// skipConverted reads past the part of the journal r that the
+// checkpoint in dir records as archived, for the log at orcPath.  A
+// chunk waiting to be archived counts as archived once it has gone
+// from both dir and orcPath.  An encrypted journal has to be
+// decrypted to find the offset, so the journal is read either way.
+func skipConverted(fsys FS, dir, orcPath string, r io.Reader) error {
+	cp, err := readCheckpoint(fsys, dir)
+	if err != nil || cp == nil {
+		return err
+	}
+	offset := cp.Offset
+	if cp.Pending != nil && !chunkWaiting(fsys, dir, orcPath, cp.StagingID) {
+		offset = *cp.Pending
+	}
+	_, err = io.CopyN(ioutil.Discard, r, offset)
+	if err == io.EOF {
+		// The offset counts a newline after the last line
+		// even if it hadn't one.
+		return nil
+	}
+	return err
+}
+
+// chunkWaiting reports whether the ORC file of the chunk written in
+// dir, staged as stagingID, is still there or at orcPath, where
+// archiveChunk moves it to be archived.
+func chunkWaiting(fsys FS, dir, orcPath, stagingID string) bool {
+	if _, err := fsys.Stat(filepath.Join(dir, filepath.Base(orcPath))); err == nil {
+		return true
+	}
+	if _, err := fsys.Stat(orcPath); err != nil {
+		return false
+	}
+	m, err := readManifest(fsys, orcPath)
+	return err == nil && m.StagingID == stagingID
+}
+
+// archiveChunk archives the files of the chunk written in dir, the
+// directory of a journal being converted, by moving each to where the
+// RotatingHandler's own files go and passing it to the ArchiveFunc.
+// It is safe to call again should it be interrupted: a chunk file, or
+// just its manifest, that has already been moved is recognised by the
+// manifest, and one that has been archived is gone.
+func (h *RotatingHandler) archiveChunk(dir, stagingID string) error {
+	var archived bool
+	for _, out := range newFileHandlers(h.fs, filepath.Join(dir, filepath.Base(h.path)), h.formats, nil) {
+		chunkPath := out.filePath()
+		target := filepath.Join(filepath.Dir(h.path), filepath.Base(chunkPath))
+
+		_, err := h.fs.Stat(chunkPath)
+		if err == nil {
+			if _, err := h.fs.Stat(target); err == nil {
+				m, merr := readManifest(h.fs, target)
+				if merr == nil && m.StagingID != stagingID {
+					return fmt.Errorf("apexorc: %s, from %s, is still waiting to be archived", target, m.StagingID)
+				}
+			}
+			// The manifest goes first, so the chunk is
+			// recognised if we're interrupted.
+			err = h.moveChunkManifest(chunkPath, target, stagingID)
+			if err != nil {
+				return err
+			}
+			err = moveFile(h.fs, chunkPath, target)
+			if err != nil {
+				return err
+			}
+		} else if os.IsNotExist(err) {
+			// Either nothing was written in this format, or
+			// an earlier attempt moved the chunk, and it may
+			// not have been archived.
+			m, merr := readManifest(h.fs, target)
+			if merr != nil || m.StagingID != stagingID {
+				continue
+			}
+			if _, err := h.fs.Stat(target); err != nil {
+				continue
+			}
+		} else {
+			return err
+		}
+
+		err = h.archiveF(target)
+		if err != nil {
+			return err
+		}
+		archived = true
+	}
+	if !archived {
+		return nil
+	}
+	err := updateCatalog(h.fs, h.path)
+	if err != nil {
+		log.WithError(err).WithField("function", "archiveChunk").Error("Error updating the catalog")
+	}
+	return nil
+}
+
+// moveChunkManifest moves the manifest of the chunk file at chunkPath
+// to go with target.  Should an earlier attempt have been interrupted
+// between moving the manifest and the chunk file, the manifest is
+// already at target, recognised by stagingID, and is left there.
+func (h *RotatingHandler) moveChunkManifest(chunkPath, target, stagingID string) error {
+	_, err := h.fs.Stat(ManifestPath(chunkPath))
+	if os.IsNotExist(err) {
+		m, merr := readManifest(h.fs, target)
+		if merr == nil && m.StagingID == stagingID {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return moveFile(h.fs, ManifestPath(chunkPath), ManifestPath(target))
+}
@@ -0,0 +1,218 @@
+package apexorc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testChunkMessages(n int) []string {
+	var msgs []string
+	for i := 1; i <= n; i++ {
+		msgs = append(msgs, fmt.Sprintf("Entry %d", i))
+	}
+	return msgs
+}
+
+// testArchivedMessages returns the messages in every archive of the
+// log at path, oldest first, along with the number of rows in each.
+func testArchivedMessages(t *testing.T, fsys FS, path string) ([]string, []int) {
+	archives, err := listArchives(fsys, path)
+	if err != nil {
+		t.Fatalf("Error listing archives: %s", err)
+	}
+	var msgs []string
+	var rows []int
+	for _, a := range archives {
+		m := testArchiveMessages(t, fsys, a.Path)
+		msgs = append(msgs, m...)
+		rows = append(rows, len(m))
+	}
+	return msgs, rows
+}
+
+func testLogChunkEntries(t *testing.T, rotator *RotatingHandler, msgs []string) {
+	for _, msg := range msgs {
+		if err := rotator.HandleLog(makeTestEntry(msg, nil, nil)); err != nil {
+			t.Fatalf("Error logging: %s", err)
+		}
+	}
+}
+
+// With ChunkedConversion, each chunk of a journal is archived as a
+// file of its own.
+func TestChunkedConversion(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys), ChunkedConversion(2))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	expected := testChunkMessages(5)
+	testLogChunkEntries(t, rotator, expected)
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+
+	msgs, rows := testArchivedMessages(t, fsys, path)
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+	if !reflect.DeepEqual(rows, []int{2, 2, 1}) {
+		t.Errorf("Expected chunks of [2 2 1] rows, got %v", rows)
+	}
+	if staged, _ := listJournalDirs(fsys, rotator.stagingDir); len(staged) != 0 {
+		t.Errorf("Expected no staged journals, found %v", staged)
+	}
+}
+
+// A chunked conversion that fails part way through carries on from
+// its checkpoint, without archiving any entry twice, and a LogSet
+// reads each entry once in the meantime.
+func TestChunkedConversionResumes(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	var calls int
+	failing := true
+	archiveF := func(oldPath string) error {
+		calls++
+		if failing && calls == 2 {
+			return errors.New("Not today")
+		}
+		return numericArchive(fsys, oldPath)
+	}
+	rotator, err := NewRotatingHandler(path, archiveF, WithFS(fsys), ChunkedConversion(2))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	rotator.SetConversionRetries(1, 0)
+	expected := testChunkMessages(5)
+	testLogChunkEntries(t, rotator, expected)
+	if err = rotator.Rotate(); err == nil {
+		t.Fatal("Expected an error rotating")
+	}
+
+	msgs, _ := testArchivedMessages(t, fsys, path)
+	if !reflect.DeepEqual(msgs, expected[:2]) {
+		t.Errorf("Expected the first chunk to be archived, got %q", msgs)
+	}
+	set, err := rotator.OpenLogSet(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Error opening log set: %s", err)
+	}
+	if msgs := testReadLogSet(t, set); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q from the log set, got %q", expected, msgs)
+	}
+	set.Close()
+
+	failing = false
+	if err = rotator.RetryQuarantined(); err != nil {
+		t.Fatalf("Error retrying: %s", err)
+	}
+	msgs, rows := testArchivedMessages(t, fsys, path)
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+	if !reflect.DeepEqual(rows, []int{2, 2, 1}) {
+		t.Errorf("Expected chunks of [2 2 1] rows, got %v", rows)
+	}
+}
+
+// Cancelling a chunked conversion keeps the chunks already archived,
+// and the next rotation carries on after them.
+func TestChunkedConversionCancelled(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	archiveF := func(oldPath string) error {
+		cancel()
+		return numericArchive(fsys, oldPath)
+	}
+	rotator, err := NewRotatingHandler(path, archiveF, WithFS(fsys), ChunkedConversion(2))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	expected := testChunkMessages(5)
+	testLogChunkEntries(t, rotator, expected)
+	if err = rotator.RotateContext(ctx); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	msgs, _ := testArchivedMessages(t, fsys, path)
+	if !reflect.DeepEqual(msgs, expected[:2]) {
+		t.Errorf("Expected the first chunk to be archived, got %q", msgs)
+	}
+
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	msgs, _ = testArchivedMessages(t, fsys, path)
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+}
+
+// A chunk whose manifest was moved to be archived, but not the chunk
+// itself, is archived by the next attempt.
+func TestChunkedConversionManifestMoved(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys), ChunkedConversion(2))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	rotator.SetConversionRetries(1, 0)
+	expected := testChunkMessages(3)
+	testLogChunkEntries(t, rotator, expected)
+
+	rotator.fs = &failingRenameFS{MemFS: fsys, path: path, failures: 1}
+	if err = rotator.Rotate(); err == nil {
+		t.Fatal("Expected an error rotating")
+	}
+	quarantined, _ := listJournalDirs(fsys, rotator.quarantineDir)
+	if len(quarantined) != 1 {
+		t.Fatalf("Expected the journal to be quarantined, found %v", quarantined)
+	}
+	chunkPath := filepath.Join(filepath.Dir(quarantined[0]), filepath.Base(path))
+	if _, err := fsys.Stat(chunkPath); err != nil {
+		t.Errorf("Expected the chunk to be left behind: %s", err)
+	}
+	if _, err := fsys.Stat(ManifestPath(chunkPath)); !os.IsNotExist(err) {
+		t.Errorf("Expected the chunk's manifest to have been moved, got %v", err)
+	}
+	if _, err := fsys.Stat(ManifestPath(path)); err != nil {
+		t.Errorf("Expected the chunk's manifest alongside the log: %s", err)
+	}
+
+	if err = rotator.RetryQuarantined(); err != nil {
+		t.Fatalf("Error retrying: %s", err)
+	}
+	msgs, rows := testArchivedMessages(t, fsys, path)
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+	if !reflect.DeepEqual(rows, []int{2, 1}) {
+		t.Errorf("Expected chunks of [2 1] rows, got %v", rows)
+	}
+}
+
+// failingRenameFS fails to rename anything to path the first failures
+// times it is asked to.
+type failingRenameFS struct {
+	*MemFS
+	path     string
+	failures int
+}
+
+func (fsys *failingRenameFS) Rename(oldpath, newpath string) error {
+	if newpath == fsys.path && fsys.failures > 0 {
+		fsys.failures--
+		return errors.New("Not today")
+	}
+	return fsys.MemFS.Rename(oldpath, newpath)
+}
@@ -0,0 +1,147 @@
+package apexorc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrHandlerClosed is returned by a RotatingHandler that has been
+// closed.
+var ErrHandlerClosed = errors.New("apexorc: handler is closed")
+
+// UnfinishedError is returned by Close when its context ends before
+// the handler has finished converting its journals.  Journals lists
+// the staged journals still waiting to be converted.  They stay in the
+// staging directory, where a LogSet will still read them, until the
+// next RotatingHandler for the log rotates or is closed.
+type UnfinishedError struct {
+	Err      error
+	Journals []string
+}
+
+func (e *UnfinishedError) Error() string {
+	if len(e.Journals) == 0 {
+		return fmt.Sprintf("apexorc: close: %s", e.Err)
+	}
+	return fmt.Sprintf("apexorc: close: %s, leaving %d journals unconverted: %s",
+		e.Err, len(e.Journals), strings.Join(e.Journals, ", "))
+}
+
+// Unwrap returns the context's error.
+func (e *UnfinishedError) Unwrap() error {
+	return e.Err
+}
+
+// Close shuts the RotatingHandler down.  It stops accepting entries,
+// converts and archives the final journal, along with any journals
+// left unconverted by earlier rotations, and waits for any
+// conversions still in progress from earlier calls to Rotate, then
+// releases the lock on the log.  An empty final journal is simply
+// removed, so nothing is left behind at the journal's path.
+//
+// Should ctx end first, conversion is abandoned as it is by
+// RotateContext, and once it has stopped and the lock is released
+// Close returns an *UnfinishedError listing the journals that haven't
+// been converted yet, or if they can't be listed an error wrapping
+// ctx's.  Otherwise the error from converting the final
+// journal, if any, is returned; as with Rotate, a journal that can't
+// be converted is quarantined.
+//
+// Once Close has been called, HandleLog and Rotate return
+// ErrHandlerClosed.
+func (h *RotatingHandler) Close(ctx context.Context) error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return ErrHandlerClosed
+	}
+	h.closed = true
+	workingPath, err := h.closeJournal()
+	h.mu.Unlock()
+	if err != nil {
+		h.unlock()
+		return err
+	}
+
+	// This also waits for any conversion under way, and converts
+	// any journals earlier rotations left behind.
+	err = h.convertStaged(ctx, workingPath)
+	h.unlock()
+	if ctx.Err() == nil || err != ctx.Err() {
+		return err
+	}
+	h.mu.Lock()
+	stagingDir := h.stagingDir
+	h.mu.Unlock()
+	// The handler may well be the one apex's log would report an
+	// error to, so a failure to list the journals is returned.
+	journals, err := listJournalDirs(h.fs, stagingDir)
+	if err != nil {
+		return fmt.Errorf("apexorc: close: %w, and unable to list the journals left unconverted: %s", ctx.Err(), err)
+	}
+	return &UnfinishedError{Err: ctx.Err(), Journals: journals}
+}
+
+// closeJournal closes the journal, or in direct mode finalises and
+// archives the output files.  A journal with entries in it is staged
+// for conversion, and its staged path returned; an empty one is
+// removed.  The caller must hold h.mu.
+func (h *RotatingHandler) closeJournal() (string, error) {
+	if h.direct {
+		outputs := h.handler.(fanOutHandler)
+		err := outputs.Close()
+		if err == nil {
+			err = h.archiveOutputs(outputs, rotationInfo{}, "")
+		}
+		return "", err
+	}
+
+	if h.handler != nil {
+		err := h.handler.Close()
+		h.handler = nil
+		if err != nil {
+			return "", err
+		}
+	}
+	empty, err := journalEmpty(h.fs, h.journalPath, h.keys)
+	if err != nil {
+		return "", err
+	}
+	if empty {
+		err = h.fs.Remove(h.journalPath)
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return h.moveJournal()
+}
+
+// journalEmpty reports whether the journal at path has no entries in
+// it, or doesn't exist.  An encrypted journal with only a header is
+// empty.
+func journalEmpty(fsys FS, path string, keys KeyProvider) (bool, error) {
+	f, err := fsys.Open(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var b [1]byte
+	// Anything unreadable is left for conversion to deal with.
+	_, err = io.ReadFull(newDecryptingReader(f, keys), b[:])
+	return err == io.EOF, nil
+}
+
+// unlock releases the lock on the log, if one was taken.
+func (h *RotatingHandler) unlock() {
+	if h.lock != nil {
+		h.lock.Close()
+	}
+}
@@ -0,0 +1,130 @@
+package apexorc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// Close converts and archives the final journal, leaves nothing at
+// the journal's path and refuses further use.
+func TestRotatingHandlerClose(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	log.SetHandler(rotator)
+	log.Info("Last words")
+
+	if err = rotator.Close(context.Background()); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	if _, err := fsys.Stat(rotator.journalPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the journal to be removed, got %v", err)
+	}
+	m, err := readManifest(fsys, path+".1")
+	if err != nil {
+		t.Fatalf("Error reading manifest: %s", err)
+	}
+	if m.Rows != 1 {
+		t.Errorf("Expected 1 row in the archive, got %d", m.Rows)
+	}
+
+	if err = rotator.HandleLog(makeTestEntry("Too late", nil, nil)); err != ErrHandlerClosed {
+		t.Errorf("Expected ErrHandlerClosed from HandleLog, got %v", err)
+	}
+	if err = rotator.Rotate(); err != ErrHandlerClosed {
+		t.Errorf("Expected ErrHandlerClosed from Rotate, got %v", err)
+	}
+	if err = rotator.Close(context.Background()); err != ErrHandlerClosed {
+		t.Errorf("Expected ErrHandlerClosed from Close, got %v", err)
+	}
+
+	// The lock was released.
+	again, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler after closing: %s", err)
+	}
+	// Closing with an empty journal archives nothing.
+	if err = again.Close(context.Background()); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	if _, err := fsys.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("Expected no second archive, got %v", err)
+	}
+	if _, err := fsys.Stat(again.journalPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the journal to be removed, got %v", err)
+	}
+}
+
+// An encrypted journal holding only its header counts as empty.
+func TestRotatingHandlerCloseEncryptedEmpty(t *testing.T) {
+	fsys := NewMemFS()
+	rotator, err := NewRotatingHandler("/testlog.orc", NumericArchiveFunc(fsys), WithFS(fsys), Encrypted(testKeyRing()))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	if err = rotator.Close(context.Background()); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	if _, err := fsys.Stat("/testlog.orc.1"); !os.IsNotExist(err) {
+		t.Errorf("Expected no archive, got %v", err)
+	}
+}
+
+// Close stops converting when its context ends, releasing the lock and
+// reporting the journals left unconverted, which the next handler for
+// the log converts.
+func TestRotatingHandlerCloseTimeout(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var calls int
+	archiveF := func(oldPath string) error {
+		calls++
+		<-ctx.Done()
+		return errors.New("Not today")
+	}
+	rotator, err := NewRotatingHandler(path, archiveF, WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	if err = rotator.HandleLog(makeTestEntry("Stuck", nil, nil)); err != nil {
+		t.Fatalf("Error logging: %s", err)
+	}
+
+	err = rotator.Close(ctx)
+	uerr, ok := err.(*UnfinishedError)
+	if !ok {
+		t.Fatalf("Expected an UnfinishedError, got %v", err)
+	}
+	if uerr.Err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got %v", uerr.Err)
+	}
+	staged, _ := listJournalDirs(fsys, rotator.stagingDir)
+	if len(staged) != 1 || !reflect.DeepEqual(uerr.Journals, staged) {
+		t.Errorf("Expected %v to be reported unfinished, got %v", staged, uerr.Journals)
+	}
+	if calls != 1 {
+		t.Errorf("Expected one attempt to archive, got %d", calls)
+	}
+
+	next, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler after closing: %s", err)
+	}
+	if err = next.Close(context.Background()); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	if msgs, _ := testArchivedMessages(t, fsys, path); !reflect.DeepEqual(msgs, []string{"Stuck"}) {
+		t.Errorf("Expected [Stuck] to be archived, got %q", msgs)
+	}
+}
@@ -0,0 +1,174 @@
+package apexorc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// KeyMapping names the keys of the JSON objects read by
+// ConvertJSONLines that hold each entry's timestamp, level and
+// message.
+type KeyMapping struct {
+	Timestamp string
+	Level     string
+	Message   string
+}
+
+// DefaultKeyMapping matches the output of github.com/apex/log's json
+// handler, and of the journal written by a RotatingHandler.
+var DefaultKeyMapping = KeyMapping{
+	Timestamp: "timestamp",
+	Level:     "level",
+	Message:   "message",
+}
+
+// ConvertJSONLines reads JSON-lines logs, with one JSON object per
+// line, from each of inputs in turn and writes them to a single ORC
+// file at orcPath, returning the number of entries written.  Any
+// input that is gzip compressed is decompressed automatically.
+//
+// The keys given by keys are used for the timestamp, level and
+// message of each entry; every other key becomes a field, with any
+// value that isn't a string stored as JSON.  An object under the key
+// "fields", as written by github.com/apex/log's json handler, is
+// merged into the entry's fields.  Timestamps may be RFC 3339 strings
+// or numbers of seconds or milliseconds since the Unix epoch, and
+// entries with no recognisable level are treated as info.  As when
+// converting a journal, lines that can't be decoded are logged and
+// skipped.
+func ConvertJSONLines(orcPath string, keys KeyMapping, inputs ...io.Reader) (int64, error) {
+	return ConvertJSONLinesFS(OSFS{}, orcPath, keys, inputs...)
+}
+
+// ConvertJSONLinesFS is like ConvertJSONLines, but writes the ORC file
+// to fsys.
+func ConvertJSONLinesFS(fsys FS, orcPath string, keys KeyMapping, inputs ...io.Reader) (int64, error) {
+	logCtx := log.WithFields(
+		log.Fields{
+			"orcPath":  orcPath,
+			"function": "ConvertJSONLines",
+		})
+	decode := func(line []byte) (*log.Entry, error) {
+		return decodeJSONLine(line, keys)
+	}
+
+	handler := NewHandler(orcPath)
+	handler.SetFS(fsys)
+	var err error
+	for _, input := range inputs {
+		var r io.Reader
+		r, err = maybeGunzip(input)
+		if err != nil {
+			break
+		}
+		err = replayJournal(context.Background(), r, decode, handler, logCtx, nil)
+		if err != nil {
+			break
+		}
+	}
+	if cerr := handler.Close(); err == nil {
+		err = cerr
+	}
+	return handler.stats.rows, err
+}
+
+// maybeGunzip returns a reader that decompresses r if it starts with
+// the gzip magic number, and otherwise reads r unchanged.
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// decodeJSONLine decodes a single line of a JSON-lines log into a
+// log.Entry, as described by ConvertJSONLines.
+func decodeJSONLine(line []byte, keys KeyMapping) (*log.Entry, error) {
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal(line, &obj)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &log.Entry{Level: log.InfoLevel, Fields: log.Fields{}}
+	if raw, ok := obj[keys.Timestamp]; ok {
+		e.Timestamp, err = decodeTimestamp(raw)
+		if err != nil {
+			return nil, err
+		}
+		delete(obj, keys.Timestamp)
+	}
+	if raw, ok := obj[keys.Level]; ok {
+		var level string
+		if json.Unmarshal(raw, &level) == nil {
+			if l, err := log.ParseLevel(level); err == nil {
+				e.Level = l
+			}
+		}
+		delete(obj, keys.Level)
+	}
+	if raw, ok := obj[keys.Message]; ok {
+		e.Message = fieldString(raw)
+		delete(obj, keys.Message)
+	}
+	if raw, ok := obj["fields"]; ok {
+		var nested map[string]json.RawMessage
+		if json.Unmarshal(raw, &nested) == nil {
+			for k, v := range nested {
+				e.Fields[k] = fieldString(v)
+			}
+			delete(obj, "fields")
+		}
+	}
+	for k, v := range obj {
+		e.Fields[k] = fieldString(v)
+	}
+	return e, nil
+}
+
+// decodeTimestamp decodes an RFC 3339 string, or a number of seconds
+// or milliseconds since the Unix epoch.
+func decodeTimestamp(raw json.RawMessage) (time.Time, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return time.Parse(time.RFC3339Nano, s)
+	}
+	n, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unrecognised timestamp %s", raw)
+	}
+	// Anything this big would be thousands of years away in
+	// seconds, so it must be milliseconds.
+	if n > 1e11 {
+		n /= 1000
+	}
+	sec := int64(n)
+	return time.Unix(sec, int64((n-float64(sec))*1e9)), nil
+}
+
+// fieldString returns a string as it is, and anything else as JSON.
+func fieldString(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, raw) != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
@@ -0,0 +1,107 @@
+package apexorc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+func TestDecodeJSONLine(t *testing.T) {
+	ts := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		line    string
+		keys    KeyMapping
+		level   log.Level
+		message string
+		fields  map[string]string
+	}{
+		{
+			`{"fields":{"user":"bilbo","age":111},"level":"warn","timestamp":"2017-01-02T03:04:05Z","message":"apex"}`,
+			DefaultKeyMapping, log.WarnLevel, "apex",
+			map[string]string{"user": "bilbo", "age": "111"},
+		},
+		{
+			`{"ts":1483326245,"severity":"error","msg":"seconds","tags":["a","b"],"ok":true}`,
+			KeyMapping{Timestamp: "ts", Level: "severity", Message: "msg"}, log.ErrorLevel, "seconds",
+			map[string]string{"tags": `["a","b"]`, "ok": "true"},
+		},
+		{
+			`{"timestamp":1483326245000,"level":"verbose","message":"millis"}`,
+			DefaultKeyMapping, log.InfoLevel, "millis",
+			map[string]string{},
+		},
+	}
+	for cid, c := range cases {
+		e, err := decodeJSONLine([]byte(c.line), c.keys)
+		if err != nil {
+			t.Fatalf("[Case %d] Error decoding: %s", cid, err)
+		}
+		if !e.Timestamp.Equal(ts) {
+			t.Errorf("[Case %d] Expected %v, got %v", cid, ts, e.Timestamp)
+		}
+		if e.Level != c.level {
+			t.Errorf("[Case %d] Expected level %s, got %s", cid, c.level, e.Level)
+		}
+		if e.Message != c.message {
+			t.Errorf("[Case %d] Expected message %q, got %q", cid, c.message, e.Message)
+		}
+		if len(e.Fields) != len(c.fields) {
+			t.Errorf("[Case %d] Expected fields %v, got %v", cid, c.fields, e.Fields)
+		}
+		for k, v := range c.fields {
+			if e.Fields.Get(k) != v {
+				t.Errorf("[Case %d] Expected %q for %q, got %v", cid, v, k, e.Fields.Get(k))
+			}
+		}
+	}
+
+	if _, err := decodeJSONLine([]byte(`{"timestamp":"yesterday"}`), DefaultKeyMapping); err == nil {
+		t.Error("Expected an error decoding an unrecognised timestamp")
+	}
+}
+
+func TestConvertJSONLines(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-convert")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	lines := `{"level":"info","timestamp":"2017-01-02T03:04:05Z","message":"one"}
+{"level":"error","timestamp":"2017-01-02T03:04:06Z","message":"two"}
+`
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(lines))
+	zw.Close()
+
+	for name, input := range map[string][]byte{"plain": []byte(lines), "gzip": compressed.Bytes()} {
+		// Feeding the converter a second, empty, input should
+		// make no difference.
+		orcPath := filepath.Join(tmpdir, name+".orc")
+		rows, err := ConvertJSONLines(orcPath, DefaultKeyMapping, bytes.NewReader(input), bytes.NewReader(nil))
+		if err != nil {
+			t.Fatalf("[%s] Error converting: %s", name, err)
+		}
+		if rows != 2 {
+			t.Errorf("[%s] Expected 2 rows, got %d", name, rows)
+		}
+		var msgs []string
+		err = replayArchive(OSFS{}, orcPath, nil, func(e *log.Entry) error {
+			msgs = append(msgs, e.Message)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("[%s] Error reading ORC file: %s", name, err)
+		}
+		if len(msgs) != 2 || msgs[0] != "one" || msgs[1] != "two" {
+			t.Errorf("[%s] Expected [one two], got %q", name, msgs)
+		}
+	}
+}
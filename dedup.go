@@ -0,0 +1,162 @@
+package apexorc
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// DedupHandler complies with the github.com/apex/log.Handler
+// interface, collapsing entries with the same level, message and
+// fields that arrive within a short window of each other into a
+// single entry before passing it on to another handler, typically a
+// RotatingHandler.  The entry passed on has the timestamp of the
+// first of them, and, if there was more than one, a RepeatCountField
+// holding how many there were and a LastTimestampField holding the
+// timestamp of the last, which RepeatOf returns.  These are written
+// to the repeat_count and last_timestamp columns of the ORC file.
+//
+// Every entry is held back for the length of the window, so that
+// later repeats can be folded into it, and entries are passed on in
+// the order they arrived.  Call Flush before rotating or closing the
+// next handler, so that nothing is left behind.
+type DedupHandler struct {
+	mu      sync.Mutex
+	next    log.Handler
+	window  time.Duration
+	pending map[string]*list.Element // pending holds an element of order for each key.
+	order   *list.List               // order lists the held entries, oldest first.
+	timer   *time.Timer
+	err     error // err is the last error from passing entries on in the background.
+}
+
+// dedupGroup is an entry held back by a DedupHandler, along with its
+// repeats.
+type dedupGroup struct {
+	key   string
+	entry *log.Entry
+	count int64
+	last  time.Time
+	due   time.Time // due is when the group is passed on.
+}
+
+// NewDedupHandler returns a DedupHandler passing entries on to next
+// once window has passed since the first of them arrived.
+func NewDedupHandler(next log.Handler, window time.Duration) *DedupHandler {
+	return &DedupHandler{
+		next:    next,
+		window:  window,
+		pending: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// HandleLog folds e into a held entry with the same level, message
+// and fields, or holds it back for the window should there be none.
+// Any entries whose window has passed are passed on.  Errors from
+// passing entries on after HandleLog has returned are returned by the
+// next call to HandleLog or Flush.
+func (h *DedupHandler) HandleLog(e *log.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	err := h.passOn(now)
+	key := dedupKey(e)
+	if el, ok := h.pending[key]; ok {
+		g := el.Value.(*dedupGroup)
+		g.count++
+		if e.Timestamp.After(g.last) {
+			g.last = e.Timestamp
+		}
+		return err
+	}
+	g := &dedupGroup{key: key, entry: e, count: 1, last: e.Timestamp, due: now.Add(h.window)}
+	h.pending[key] = h.order.PushBack(g)
+	if h.timer == nil {
+		h.timer = time.AfterFunc(h.window, h.expire)
+	}
+	return err
+}
+
+// Flush passes every held entry on immediately.
+func (h *DedupHandler) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.passOn(time.Time{})
+}
+
+// expire is called by the timer to pass on entries whose window has
+// passed when no new entries have arrived to do it.
+func (h *DedupHandler) expire() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.timer = nil
+	err := h.passOn(time.Now())
+	if err != nil {
+		h.err = err
+	}
+	if front := h.order.Front(); front != nil && h.timer == nil {
+		h.timer = time.AfterFunc(time.Until(front.Value.(*dedupGroup).due), h.expire)
+	}
+}
+
+// passOn passes on the held entries that are due by now, or all of
+// them if now is zero, along with any error from earlier.  The caller
+// must hold h.mu.
+func (h *DedupHandler) passOn(now time.Time) error {
+	err := h.err
+	h.err = nil
+	for {
+		front := h.order.Front()
+		if front == nil {
+			break
+		}
+		g := front.Value.(*dedupGroup)
+		if !now.IsZero() && g.due.After(now) {
+			break
+		}
+		h.order.Remove(front)
+		delete(h.pending, g.key)
+		if herr := h.next.HandleLog(g.repeated()); err == nil {
+			err = herr
+		}
+	}
+	if h.order.Len() == 0 && h.timer != nil {
+		h.timer.Stop()
+		h.timer = nil
+	}
+	return err
+}
+
+// repeated returns the entry that stands for the group.
+func (g *dedupGroup) repeated() *log.Entry {
+	if g.count == 1 {
+		return g.entry
+	}
+	e := *g.entry
+	e.Fields = make(log.Fields, len(g.entry.Fields)+2)
+	for k, v := range g.entry.Fields {
+		e.Fields[k] = v
+	}
+	e.Fields[RepeatCountField] = repeatCount(g.count)
+	e.Fields[LastTimestampField] = repeatLast{g.last}
+	return &e
+}
+
+// dedupKey identifies the entries that are considered identical.
+func dedupKey(e *log.Entry) string {
+	names := e.Fields.Names()
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d\x00%s", e.Level, e.Message)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\x00%s=%v", name, e.Fields[name])
+	}
+	return b.String()
+}
@@ -0,0 +1,142 @@
+package apexorc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+func TestDedupHandler(t *testing.T) {
+	rec := &entryRecorder{}
+	h := NewDedupHandler(rec, time.Hour)
+	start := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		h.HandleLog(&log.Entry{Level: log.ErrorLevel, Message: "Storm", Timestamp: start.Add(time.Duration(i) * time.Second), Fields: log.Fields{"host": "a"}})
+	}
+	h.HandleLog(&log.Entry{Level: log.ErrorLevel, Message: "Storm", Timestamp: start, Fields: log.Fields{"host": "b"}})
+	h.HandleLog(&log.Entry{Level: log.WarnLevel, Message: "Storm", Timestamp: start, Fields: log.Fields{"host": "a"}})
+	if len(rec.entries) != 0 {
+		t.Fatalf("Expected entries to be held back, got %d", len(rec.entries))
+	}
+	if err := h.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if len(rec.entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(rec.entries))
+	}
+	e := rec.entries[0]
+	if count, last := RepeatOf(e); count != 3 || !last.Equal(start.Add(2*time.Second)) || !e.Timestamp.Equal(start) {
+		t.Errorf("Unexpected collapsed entry %+v", e)
+	}
+	for _, e := range rec.entries[1:] {
+		if _, ok := e.Fields[RepeatCountField]; ok {
+			t.Errorf("Unexpected repeat count on %+v", e)
+		}
+	}
+}
+
+// Held entries are passed on once their window passes, even if
+// nothing else is logged.
+func TestDedupHandlerWindow(t *testing.T) {
+	rec := &entryRecorder{}
+	h := NewDedupHandler(rec, 20*time.Millisecond)
+	h.HandleLog(&log.Entry{Level: log.InfoLevel, Message: "Once"})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if len(rec.recorded()) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the entry to be passed on")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// The repeat columns survive the journal and the ORC file.
+func TestDedupRoundTrip(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-dedup")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, NumericArchiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	h := NewDedupHandler(rotator, time.Hour)
+	start := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	h.HandleLog(&log.Entry{Level: log.ErrorLevel, Message: "Storm", Timestamp: start})
+	h.HandleLog(&log.Entry{Level: log.ErrorLevel, Message: "Storm", Timestamp: start.Add(time.Minute)})
+	if err = h.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+
+	m, err := ReadManifest(path + ".1")
+	if err != nil {
+		t.Fatalf("Error reading manifest: %s", err)
+	}
+	if m.Rows != 1 || !m.MaxTimestamp.Equal(start.Add(time.Minute)) {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+	var entries []*log.Entry
+	err = replayArchive(OSFS{}, path+".1", nil, func(e *log.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading archive: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	if count, last := RepeatOf(entries[0]); count != 2 || !last.Equal(start.Add(time.Minute)) {
+		t.Errorf("Unexpected entry %+v", entries[0])
+	}
+}
+
+// Fields that happen to share the names of the repeat fields, but
+// weren't set by a DedupHandler, are kept as ordinary fields.
+func TestRepeatFieldNamesLeftAlone(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	fields := log.Fields{RepeatCountField: "7", LastTimestampField: "yesterday"}
+	if err = rotator.HandleLog(makeTestEntry("Mine", fields, nil)); err != nil {
+		t.Fatalf("Error logging: %s", err)
+	}
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+
+	var entries []*log.Entry
+	err = replayArchive(fsys, path+".1", nil, func(e *log.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading archive: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Fields[RepeatCountField] != "7" || e.Fields[LastTimestampField] != "yesterday" {
+		t.Errorf("Expected the fields to be kept, got %v", e.Fields)
+	}
+	if count, last := RepeatOf(e); count != 1 || !last.Equal(e.Timestamp) {
+		t.Errorf("Expected no repeats, got %d until %s", count, last)
+	}
+}
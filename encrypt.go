@@ -0,0 +1,598 @@
+package apexorc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// envelopeMagic starts every file written with encryption enabled.
+var envelopeMagic = []byte("AOENC\x01")
+
+// The sizes of the parts of an encrypted file.  Each file has its own
+// randomly generated data key, which is stored in the header wrapped
+// by a key from a KeyProvider.  The header is followed by frames, each
+// holding the length of the rest of the frame, a flags byte, a random
+// nonce and the sealed data.  Each frame's number in the file and its
+// flags are authenticated along with its data, so frames can't be
+// reordered, repeated or dropped without it being noticed.
+const (
+	dataKeySize      = 32
+	nonceSize        = 12
+	frameLengthSize  = 4
+	frameFlagsSize   = 1
+	maxFramePlain    = 64 * 1024
+	maxFrameSealed   = frameFlagsSize + nonceSize + maxFramePlain + 16
+	maxEnvelopeField = 1024
+)
+
+// maxEnvelopeHeader is the longest an encrypted file's header can be.
+var maxEnvelopeHeader = len(envelopeMagic) + 1 + 2*(2+maxEnvelopeField)
+
+// The flags of an encrypted file's header, which follow envelopeMagic
+// and are authenticated along with its data key.  A sealed file, such
+// as an archive, is complete once written, and ends with a final
+// frame, so that a truncated file is detected; a journal isn't.
+const envelopeSealed byte = 1
+
+// The flags of a frame.  frameFinal marks the empty frame that ends a
+// sealed file.
+const frameFinal byte = 1
+
+// envelopeHeader is the parsed header of an encrypted file.
+type envelopeHeader struct {
+	flags   byte
+	keyID   string
+	wrapped []byte
+}
+
+// ErrNoKeyProvider is returned when reading an encrypted file without
+// a KeyProvider.
+var ErrNoKeyProvider = errors.New("apexorc: file is encrypted, but no key provider was given")
+
+// KeyProvider supplies the keys used to encrypt journals and archives.
+// Each file is encrypted with its own data key, which is in turn
+// encrypted with the provider's current key and stored, along with
+// that key's ID, in the file's header.  Keys must be 16, 24 or 32
+// bytes long, selecting AES-128, AES-192 or AES-256.  Old keys must
+// remain available from Key for as long as files encrypted with them
+// need to be read.
+type KeyProvider interface {
+	// CurrentKey returns the key, and its ID, to encrypt new
+	// files with.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given ID.
+	Key(id string) ([]byte, error)
+}
+
+// KeyRing is a KeyProvider that holds its keys in memory.
+type KeyRing struct {
+	Current string            `json:"current"`
+	Keys    map[string][]byte `json:"keys"`
+}
+
+// CurrentKey returns the key named by Current.
+func (k *KeyRing) CurrentKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+// Key returns the key with the given ID.
+func (k *KeyRing) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("apexorc: no key with ID %q", id)
+	}
+	return key, nil
+}
+
+// LoadKeyRing reads a KeyRing from a JSON file holding the ID of the
+// current key and the keys themselves, base64 encoded:
+//
+//	{"current": "2017-06", "keys": {"2017-01": "...", "2017-06": "..."}}
+func LoadKeyRing(path string) (*KeyRing, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var encoded struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}
+	err = json.Unmarshal(b, &encoded)
+	if err != nil {
+		return nil, err
+	}
+	k := &KeyRing{Current: encoded.Current, Keys: make(map[string][]byte, len(encoded.Keys))}
+	for id, key := range encoded.Keys {
+		k.Keys[id], err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("apexorc: key %q: %s", id, err)
+		}
+	}
+	return k, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// envelopeWriter encrypts everything written to it, writing each call
+// to Write as one or more frames.  frames counts the frames written so
+// far, including any already in a file being appended to.
+type envelopeWriter struct {
+	w        io.Writer
+	aead     cipher.AEAD
+	frames   uint64
+	sealed   bool
+	finished bool
+}
+
+// newEnvelopeWriter writes the header of a new encrypted file to w,
+// using a fresh data key wrapped by keys' current key.  A sealed file
+// must be completed by calling finish, or Close, once everything has
+// been written to it; one that isn't, such as a journal, can be
+// appended to later with resumeEnvelopeWriter.
+func newEnvelopeWriter(w io.Writer, keys KeyProvider, sealed bool) (*envelopeWriter, error) {
+	keyID, kek, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(keyID) > maxEnvelopeField {
+		return nil, fmt.Errorf("apexorc: key ID %q is too long", keyID)
+	}
+	wrapper, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	dataKey := make([]byte, dataKeySize)
+	nonce := make([]byte, nonceSize)
+	if _, err = rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	var flags byte
+	if sealed {
+		flags = envelopeSealed
+	}
+	wrapped := wrapper.Seal(nonce, nonce, dataKey, envelopeAAD(flags, keyID))
+
+	var header bytes.Buffer
+	header.Write(envelopeMagic)
+	header.WriteByte(flags)
+	binary.Write(&header, binary.BigEndian, uint16(len(keyID)))
+	header.WriteString(keyID)
+	binary.Write(&header, binary.BigEndian, uint16(len(wrapped)))
+	header.Write(wrapped)
+	if _, err = w.Write(header.Bytes()); err != nil {
+		return nil, err
+	}
+	return &envelopeWriter{w: w, aead: aead, sealed: sealed}, nil
+}
+
+// resumeEnvelopeWriter returns an envelopeWriter that appends frames
+// to the existing encrypted file f, which must be open for reading as
+// well as appending, and mustn't be sealed.  An incomplete frame at
+// the end of the file, left by a crash part way through a write, is
+// cut off first so that the frames that follow it can still be read.
+func resumeEnvelopeWriter(f File, keys KeyProvider) (*envelopeWriter, error) {
+	buf := make([]byte, maxEnvelopeHeader)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	header, headerLen, err := parseEnvelopeHeader(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	if headerLen == 0 {
+		return nil, errors.New("apexorc: incomplete encrypted header")
+	}
+	if header.flags&envelopeSealed != 0 {
+		return nil, errors.New("apexorc: can't append to a sealed encrypted file")
+	}
+	aead, err := unwrapDataKey(keys, header)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	end, frames, err := completeFramesEnd(f, int64(headerLen), info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if end < info.Size() {
+		err = f.Truncate(end)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &envelopeWriter{w: f, aead: aead, frames: frames}, nil
+}
+
+// completeFramesEnd returns the offset just after the last complete
+// frame of the first size bytes of r, where the first frame starts at
+// offset, along with the number of complete frames.
+func completeFramesEnd(r io.ReaderAt, offset, size int64) (int64, uint64, error) {
+	var length [frameLengthSize]byte
+	var frames uint64
+	for offset+frameLengthSize <= size {
+		_, err := r.ReadAt(length[:], offset)
+		if err != nil {
+			return offset, frames, err
+		}
+		next := offset + frameLengthSize + int64(binary.BigEndian.Uint32(length[:]))
+		if next > size {
+			break
+		}
+		offset = next
+		frames++
+	}
+	return offset, frames, nil
+}
+
+func envelopeAAD(flags byte, keyID string) []byte {
+	return append(append(append([]byte{}, envelopeMagic...), flags), keyID...)
+}
+
+// frameAAD returns the additional data authenticated with the frame
+// numbered n, counting from zero, which has the given flags.
+func frameAAD(n uint64, flags byte) []byte {
+	var aad [9]byte
+	binary.BigEndian.PutUint64(aad[:], n)
+	aad[8] = flags
+	return aad[:]
+}
+
+func (e *envelopeWriter) Write(p []byte) (int, error) {
+	if e.finished {
+		return 0, errors.New("apexorc: write to a finished encrypted file")
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxFramePlain {
+			chunk = chunk[:maxFramePlain]
+		}
+		if err := e.writeFrame(chunk, 0); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// writeFrame seals chunk in the next frame, with the given flags.
+func (e *envelopeWriter) writeFrame(chunk []byte, flags byte) error {
+	headerSize := frameLengthSize + frameFlagsSize + nonceSize
+	frame := make([]byte, headerSize, headerSize+len(chunk)+e.aead.Overhead())
+	frame[frameLengthSize] = flags
+	nonce := frame[frameLengthSize+frameFlagsSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	frame = e.aead.Seal(frame, nonce, chunk, frameAAD(e.frames, flags))
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-frameLengthSize))
+	// A frame is written in one go so that a journal never has
+	// one frame interleaved with another.
+	if _, err := e.w.Write(frame); err != nil {
+		return err
+	}
+	e.frames++
+	return nil
+}
+
+// finish completes a sealed file by writing its final frame.  Nothing
+// more can be written once it has been called.
+func (e *envelopeWriter) finish() error {
+	if !e.sealed || e.finished {
+		return nil
+	}
+	e.finished = true
+	return e.writeFrame(nil, frameFinal)
+}
+
+// Close finishes a sealed file, then closes the underlying writer, if
+// it can be closed.
+func (e *envelopeWriter) Close() error {
+	err := e.finish()
+	if c, ok := e.w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// envelopeDecoder turns the bytes of a file, as they are read, back
+// into plaintext.  Files that don't start with envelopeMagic are
+// passed through unchanged, so readers needn't know in advance
+// whether a file is encrypted.  frames counts the frames decoded so
+// far, and finished is set once the final frame of a sealed file has
+// been.
+type envelopeDecoder struct {
+	keys      KeyProvider
+	buf       []byte
+	decided   bool
+	encrypted bool
+	sealed    bool
+	aead      cipher.AEAD
+	frames    uint64
+	finished  bool
+}
+
+// feed adds p to the bytes read so far and returns all of the
+// plaintext that can now be decoded.  Incomplete frames are held back
+// until the rest of them is fed in.
+func (d *envelopeDecoder) feed(p []byte) ([]byte, error) {
+	if d.decided && !d.encrypted {
+		return p, nil
+	}
+	d.buf = append(d.buf, p...)
+	if !d.decided {
+		if len(d.buf) < len(envelopeMagic) && bytes.HasPrefix(envelopeMagic, d.buf) {
+			return nil, nil
+		}
+		d.decided = true
+		d.encrypted = bytes.HasPrefix(d.buf, envelopeMagic)
+		if !d.encrypted {
+			plain := d.buf
+			d.buf = nil
+			return plain, nil
+		}
+	}
+	if d.aead == nil {
+		ok, err := d.readHeader()
+		if err != nil || !ok {
+			return nil, err
+		}
+	}
+
+	var plain []byte
+	for len(d.buf) >= frameLengthSize {
+		if d.finished {
+			return plain, errors.New("apexorc: data after the final encrypted frame")
+		}
+		n := int(binary.BigEndian.Uint32(d.buf))
+		if n < frameFlagsSize+nonceSize+d.aead.Overhead() || n > maxFrameSealed {
+			return plain, errors.New("apexorc: corrupt encrypted frame")
+		}
+		if len(d.buf) < frameLengthSize+n {
+			break
+		}
+		frame := d.buf[frameLengthSize : frameLengthSize+n]
+		flags := frame[0]
+		sealed := frame[frameFlagsSize:]
+		var err error
+		plain, err = d.aead.Open(plain, sealed[:nonceSize], sealed[nonceSize:], frameAAD(d.frames, flags))
+		if err != nil {
+			return plain, fmt.Errorf("apexorc: unable to decrypt frame %d: %s", d.frames, err)
+		}
+		if flags&frameFinal != 0 {
+			if !d.sealed {
+				return plain, errors.New("apexorc: final frame in an encrypted journal")
+			}
+			d.finished = true
+		}
+		d.frames++
+		d.buf = d.buf[frameLengthSize+n:]
+	}
+	return plain, nil
+}
+
+// end is called once the whole of a file has been fed to the decoder.
+// It returns an error if the file is sealed but didn't end with its
+// final frame, as it has been truncated.  Anything left over of a
+// journal is an incomplete frame, as left by a journal that is still
+// being written, or by a crash part way through a write.
+func (d *envelopeDecoder) end() error {
+	if d.sealed && (!d.finished || len(d.buf) > 0) {
+		return errors.New("apexorc: encrypted file is truncated")
+	}
+	return nil
+}
+
+// readHeader unwraps the data key from the header at the start of
+// buf, returning false if the header isn't complete yet.
+func (d *envelopeDecoder) readHeader() (bool, error) {
+	if d.keys == nil {
+		return false, ErrNoKeyProvider
+	}
+	header, n, err := parseEnvelopeHeader(d.buf)
+	if err != nil || n == 0 {
+		return false, err
+	}
+	d.aead, err = unwrapDataKey(d.keys, header)
+	if err != nil {
+		return false, err
+	}
+	d.sealed = header.flags&envelopeSealed != 0
+	d.buf = d.buf[n:]
+	return true, nil
+}
+
+// parseEnvelopeHeader parses the header at the start of b, returning
+// it along with its length.  The length is zero if b doesn't hold the
+// whole header.
+func parseEnvelopeHeader(b []byte) (envelopeHeader, int, error) {
+	var header envelopeHeader
+	if !bytes.HasPrefix(b, envelopeMagic) {
+		return header, 0, errors.New("apexorc: file is not encrypted")
+	}
+	n := len(envelopeMagic)
+	if len(b) < n+1 {
+		return header, 0, nil
+	}
+	header.flags = b[n]
+	n++
+	var fields [2][]byte
+	for i := range fields {
+		if len(b) < n+2 {
+			return header, 0, nil
+		}
+		l := int(binary.BigEndian.Uint16(b[n:]))
+		if l > maxEnvelopeField {
+			return header, 0, errors.New("apexorc: corrupt encrypted header")
+		}
+		if len(b) < n+2+l {
+			return header, 0, nil
+		}
+		fields[i] = b[n+2 : n+2+l]
+		n += 2 + l
+	}
+	if len(fields[1]) < nonceSize {
+		return header, 0, errors.New("apexorc: corrupt encrypted header")
+	}
+	header.keyID = string(fields[0])
+	header.wrapped = fields[1]
+	return header, n, nil
+}
+
+// unwrapDataKey decrypts the data key in header, which is wrapped with
+// the key header.keyID.
+func unwrapDataKey(keys KeyProvider, header envelopeHeader) (cipher.AEAD, error) {
+	kek, err := keys.Key(header.keyID)
+	if err != nil {
+		return nil, err
+	}
+	unwrapper, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	wrapped := header.wrapped
+	dataKey, err := unwrapper.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], envelopeAAD(header.flags, header.keyID))
+	if err != nil {
+		return nil, fmt.Errorf("apexorc: unable to unwrap data key with key %q: %s", header.keyID, err)
+	}
+	return newGCM(dataKey)
+}
+
+// envelopeKeyID returns the ID of the key that the file read by r was
+// encrypted with, or false if it isn't encrypted.
+func envelopeKeyID(r io.Reader) (string, bool) {
+	b := make([]byte, maxEnvelopeHeader)
+	n, _ := io.ReadFull(r, b)
+	header, l, err := parseEnvelopeHeader(b[:n])
+	if err != nil || l == 0 {
+		return "", false
+	}
+	return header.keyID, true
+}
+
+// finish returns anything held back by a decoder that never saw
+// enough bytes to tell whether its file was encrypted.  Such a file
+// is too short to be anything but plaintext.
+func (d *envelopeDecoder) finish() []byte {
+	if d.decided {
+		return nil
+	}
+	d.decided = true
+	plain := d.buf
+	d.buf = nil
+	return plain
+}
+
+// decryptingReader reads the plaintext of a file that may or may not
+// be encrypted.  An incomplete frame at the end of a journal, as left
+// by a journal that is still being written, is treated as the end of
+// the file, but a sealed file that doesn't end with its final frame
+// is reported as truncated.
+type decryptingReader struct {
+	r       io.Reader
+	decoder envelopeDecoder
+	buf     []byte // buf is what r is read into to be decrypted.
+	plain   []byte
+	err     error
+}
+
+// decryptingReadSize is how much is read at a time to be decrypted.
+const decryptingReadSize = 32 * 1024
+
+// newDecryptingReader returns a reader of the plaintext of r, using
+// keys to decrypt it if it is encrypted.  keys may be nil if the file
+// is known not to be encrypted.
+func newDecryptingReader(r io.Reader, keys KeyProvider) *decryptingReader {
+	return &decryptingReader{r: r, decoder: envelopeDecoder{keys: keys}}
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	if len(d.plain) == 0 && d.err == nil && d.decoder.decided && !d.decoder.encrypted {
+		// There's nothing to decrypt.
+		return d.r.Read(p)
+	}
+	if d.buf == nil {
+		d.buf = make([]byte, decryptingReadSize)
+	}
+	for len(d.plain) == 0 && d.err == nil {
+		n, err := d.r.Read(d.buf)
+		if n > 0 {
+			var derr error
+			d.plain, derr = d.decoder.feed(d.buf[:n])
+			if derr != nil {
+				err = derr
+			}
+		}
+		if err == io.EOF {
+			d.plain = append(d.plain, d.decoder.finish()...)
+			if eerr := d.decoder.end(); eerr != nil {
+				err = eerr
+			}
+		}
+		d.err = err
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	if n > 0 {
+		return n, nil
+	}
+	return 0, d.err
+}
+
+// Close closes the underlying reader, if it can be closed.
+func (d *decryptingReader) Close() error {
+	if c, ok := d.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// DecryptFile writes the plaintext of the file at path, a journal or
+// archive written with encryption enabled, to w.  Files that aren't
+// encrypted are copied unchanged.
+func DecryptFile(w io.Writer, path string, keys KeyProvider) error {
+	return DecryptFileFS(OSFS{}, w, path, keys)
+}
+
+// DecryptFileFS is like DecryptFile, but reads the file from fsys.
+func DecryptFileFS(fsys FS, w io.Writer, path string, keys KeyProvider) error {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, newDecryptingReader(bufio.NewReader(f), keys))
+	return err
+}
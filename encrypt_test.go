@@ -0,0 +1,293 @@
+package apexorc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+func testKeyRing() *KeyRing {
+	return &KeyRing{
+		Current: "one",
+		Keys: map[string][]byte{
+			"one": bytes.Repeat([]byte{1}, 32),
+			"two": bytes.Repeat([]byte{2}, 32),
+		},
+	}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	keys := testKeyRing()
+	plain := bytes.Repeat([]byte("Encrypted at rest\n"), 10000)
+
+	var buf bytes.Buffer
+	w, err := newEnvelopeWriter(&buf, keys, false)
+	if err != nil {
+		t.Fatalf("Error from newEnvelopeWriter: %s", err)
+	}
+	if _, err = w.Write(plain); err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("Encrypted at rest")) {
+		t.Fatal("Plaintext found in encrypted output")
+	}
+	if keyID, ok := envelopeKeyID(bytes.NewReader(buf.Bytes())); !ok || keyID != "one" {
+		t.Errorf("Expected key ID \"one\", got %q", keyID)
+	}
+
+	// Files written with an old key can still be read once the
+	// current key has changed.
+	keys.Current = "two"
+	got, err := ioutil.ReadAll(newDecryptingReader(bytes.NewReader(buf.Bytes()), keys))
+	if err != nil {
+		t.Fatalf("Error decrypting: %s", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("Decrypted %d bytes, expected %d", len(got), len(plain))
+	}
+
+	_, err = ioutil.ReadAll(newDecryptingReader(bytes.NewReader(buf.Bytes()), nil))
+	if err != ErrNoKeyProvider {
+		t.Errorf("Expected ErrNoKeyProvider, got %v", err)
+	}
+}
+
+// testSplitFrames splits an encrypted file into its header and frames.
+func testSplitFrames(t *testing.T, b []byte) ([]byte, [][]byte) {
+	_, n, err := parseEnvelopeHeader(b)
+	if err != nil || n == 0 {
+		t.Fatalf("Error parsing header: %v", err)
+	}
+	header, rest := b[:n], b[n:]
+	var frames [][]byte
+	for len(rest) > 0 {
+		l := frameLengthSize + int(binary.BigEndian.Uint32(rest))
+		frames = append(frames, rest[:l])
+		rest = rest[l:]
+	}
+	return header, frames
+}
+
+// Frames of a sealed file can't be reordered, repeated or dropped, nor
+// the file cut short, without it failing to decrypt.
+func TestEnvelopeTampering(t *testing.T) {
+	keys := testKeyRing()
+	var buf bytes.Buffer
+	w, err := newEnvelopeWriter(&buf, keys, true)
+	if err != nil {
+		t.Fatalf("Error from newEnvelopeWriter: %s", err)
+	}
+	for _, chunk := range []string{"one\n", "two\n", "three\n"} {
+		if _, err = w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	header, frames := testSplitFrames(t, buf.Bytes())
+	if len(frames) != 4 {
+		t.Fatalf("Expected 3 frames and a final one, got %d", len(frames))
+	}
+
+	join := func(frames ...[]byte) []byte {
+		return bytes.Join(append([][]byte{header}, frames...), nil)
+	}
+	got, err := ioutil.ReadAll(newDecryptingReader(bytes.NewReader(join(frames...)), keys))
+	if err != nil || string(got) != "one\ntwo\nthree\n" {
+		t.Fatalf("Expected the file to decrypt, got %q, %v", got, err)
+	}
+
+	for name, b := range map[string][]byte{
+		"reordered": join(frames[1], frames[0], frames[2], frames[3]),
+		"repeated":  join(frames[0], frames[0], frames[1], frames[2], frames[3]),
+		"dropped":   join(frames[0], frames[2], frames[3]),
+		"truncated": join(frames[:3]...),
+		"torn":      join(frames[0], frames[1], frames[2], frames[3][:5]),
+		"extended":  join(frames[0], frames[1], frames[2], frames[3], frames[1]),
+	} {
+		if _, err := ioutil.ReadAll(newDecryptingReader(bytes.NewReader(b), keys)); err == nil {
+			t.Errorf("Expected an error reading a %s file", name)
+		}
+	}
+
+	// A journal isn't sealed, so it can be read, and appended to,
+	// part way through being written.
+	buf.Reset()
+	w, err = newEnvelopeWriter(&buf, keys, false)
+	if err != nil {
+		t.Fatalf("Error from newEnvelopeWriter: %s", err)
+	}
+	w.Write([]byte("one\n"))
+	w.Write([]byte("two\n"))
+	header, frames = testSplitFrames(t, buf.Bytes())
+	got, err = ioutil.ReadAll(newDecryptingReader(bytes.NewReader(join(frames[0], frames[1][:5])), keys))
+	if err != nil || string(got) != "one\n" {
+		t.Errorf("Expected to read the complete frame of a journal, got %q, %v", got, err)
+	}
+	if _, err = ioutil.ReadAll(newDecryptingReader(bytes.NewReader(join(frames[1], frames[0])), keys)); err == nil {
+		t.Error("Expected an error reading reordered journal frames")
+	}
+}
+
+// Files that aren't encrypted, however short, are read unchanged.
+func TestDecryptingReaderPlaintext(t *testing.T) {
+	for _, plain := range []string{"", "{}", "{\"message\":\"hello\"}\n"} {
+		got, err := ioutil.ReadAll(newDecryptingReader(strings.NewReader(plain), testKeyRing()))
+		if err != nil {
+			t.Fatalf("Error reading %q: %s", plain, err)
+		}
+		if string(got) != plain {
+			t.Errorf("Got %q, expected %q", got, plain)
+		}
+	}
+
+	// Reading doesn't allocate, once it's known there's nothing to
+	// decrypt.
+	r := newDecryptingReader(strings.NewReader(strings.Repeat("{}\n", 1000)), testKeyRing())
+	p := make([]byte, 3)
+	r.Read(p)
+	if allocs := testing.AllocsPerRun(100, func() { r.Read(p) }); allocs != 0 {
+		t.Errorf("Expected no allocations reading, got %v", allocs)
+	}
+}
+
+// A journal left with half a frame by a crash loses only that frame,
+// both when read and when appended to.
+func TestEncryptedJournalResume(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-encrypt")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+	keys := testKeyRing()
+	path := filepath.Join(tmpdir, "testlog.jrnl")
+
+	h, err := newJournalHandlerForPath(OSFS{}, path, keys)
+	if err != nil {
+		t.Fatalf("Error creating journal: %s", err)
+	}
+	h.HandleLog(&log.Entry{Message: "First", Level: log.InfoLevel})
+	h.HandleLog(&log.Entry{Message: "Torn", Level: log.InfoLevel})
+	h.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err = openJournalHandlerForPath(OSFS{}, path, keys)
+	if err != nil {
+		t.Fatalf("Error reopening journal: %s", err)
+	}
+	h.HandleLog(&log.Entry{Message: "Second", Level: log.InfoLevel})
+	h.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := newJournalSource(newDecryptingReader(f, keys))
+	defer src.Close()
+	var msgs []string
+	for {
+		e, err := src.next()
+		if err != nil {
+			break
+		}
+		msgs = append(msgs, e.Message)
+	}
+	expected := []string{"First", "Second"}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+}
+
+func TestEncryptedRotatingHandler(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-encrypt")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+	keys := testKeyRing()
+	path := filepath.Join(tmpdir, "testlog.orc")
+
+	rotator, err := NewRotatingHandler(path, NumericArchiveF, Encrypted(keys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	log.SetHandler(rotator)
+	log.Info("Secret archived")
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	log.Info("Secret journalled")
+
+	for _, p := range []string{path + ".1", makeJournalPathFromPath(path)} {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(b, []byte("Secret")) {
+			t.Errorf("Plaintext found in %q", p)
+		}
+	}
+	m, err := ReadManifest(path + ".1")
+	if err != nil {
+		t.Fatalf("Error reading manifest: %s", err)
+	}
+	if m.KeyID != "one" {
+		t.Errorf("Expected manifest key ID \"one\", got %q", m.KeyID)
+	}
+
+	set, err := OpenEncryptedLogSet(path, keys, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Error opening log set: %s", err)
+	}
+	msgs := testReadLogSet(t, set)
+	set.Close()
+	expected := []string{"Secret archived", "Secret journalled"}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+
+	if _, err = OpenLogSet(path, time.Time{}, time.Time{}); err != ErrNoKeyProvider {
+		t.Errorf("Expected ErrNoKeyProvider without keys, got %v", err)
+	}
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-encrypt")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+	key := bytes.Repeat([]byte{7}, 32)
+	path := filepath.Join(tmpdir, "keys.json")
+	err = ioutil.WriteFile(path, []byte(`{"current": "k1", "keys": {"k1": "`+base64.StdEncoding.EncodeToString(key)+`"}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadKeyRing(path)
+	if err != nil {
+		t.Fatalf("Error loading key ring: %s", err)
+	}
+	id, got, err := keys.CurrentKey()
+	if err != nil || id != "k1" || !bytes.Equal(got, key) {
+		t.Errorf("Got key %q %v (%v)", id, got, err)
+	}
+	if _, err = keys.Key("k2"); err == nil {
+		t.Error("Expected an error for a missing key")
+	}
+}
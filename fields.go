@@ -0,0 +1,143 @@
+package apexorc
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// fieldTypesColumn is the column, of ORC and Parquet files, that
+// records the type of each field whose value wasn't a string, so that
+// it can be read back as it was logged.  The fields column holds every
+// value as a string; integers and floats in decimal, times in RFC 3339
+// format with nanoseconds, durations as by time.Duration.String, and
+// anything else as JSON.
+const fieldTypesColumn = "field_types"
+
+// The types that a field may be recorded as having, in the field_types
+// column or in a journal.  A field that isn't recorded as having one
+// is a string.
+const (
+	fieldTypeInt         = "int"
+	fieldTypeUint        = "uint"
+	fieldTypeFloat       = "float"
+	fieldTypeBool        = "bool"
+	fieldTypeDuration    = "duration"
+	fieldTypeTime        = "time"
+	fieldTypeJSON        = "json"
+	fieldTypeRepeatCount = "repeat_count"
+	fieldTypeRepeatLast  = "repeat_last"
+)
+
+// formatField returns the string that the field value v is stored as,
+// and the type to record for it, which is empty for a string.  Errors
+// and other values that describe themselves are stored as strings,
+// by their Error or String methods, as are values that can't be
+// marshalled as JSON, as fmt would print them, as they can't be
+// brought back anyway.
+func formatField(v interface{}) (string, string) {
+	switch x := v.(type) {
+	case string:
+		return x, ""
+	case repeatCount:
+		return strconv.FormatInt(int64(x), 10), fieldTypeRepeatCount
+	case repeatLast:
+		return x.Format(time.RFC3339Nano), fieldTypeRepeatLast
+	case time.Duration:
+		return x.String(), fieldTypeDuration
+	case time.Time:
+		return x.Format(time.RFC3339Nano), fieldTypeTime
+	case error:
+		return x.Error(), ""
+	case fmt.Stringer:
+		return x.String(), ""
+	case nil:
+		return "null", fieldTypeJSON
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), fieldTypeInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), fieldTypeUint
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64), fieldTypeFloat
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), fieldTypeBool
+	case reflect.String:
+		return rv.String(), ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v), ""
+	}
+	return string(b), fieldTypeJSON
+}
+
+// parseField is the reverse of formatField, returning the value of a
+// field stored as s with type t: an int64, uint64, float64, bool,
+// time.Duration or time.Time, or for JSON, whatever encoding/json
+// decodes it to.  s itself is returned if t is empty or unknown, or s
+// can't be parsed.
+func parseField(s, t string) interface{} {
+	var v interface{}
+	var err error
+	switch t {
+	case fieldTypeInt:
+		v, err = strconv.ParseInt(s, 10, 64)
+	case fieldTypeUint:
+		v, err = strconv.ParseUint(s, 10, 64)
+	case fieldTypeFloat:
+		v, err = strconv.ParseFloat(s, 64)
+	case fieldTypeBool:
+		v, err = strconv.ParseBool(s)
+	case fieldTypeDuration:
+		v, err = time.ParseDuration(s)
+	case fieldTypeTime:
+		v, err = time.Parse(time.RFC3339Nano, s)
+	case fieldTypeJSON:
+		err = json.Unmarshal([]byte(s), &v)
+	case fieldTypeRepeatCount:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 64)
+		v = repeatCount(n)
+	case fieldTypeRepeatLast:
+		var last time.Time
+		last, err = time.Parse(time.RFC3339Nano, s)
+		v = repeatLast{last}
+	default:
+		return s
+	}
+	if err != nil {
+		return s
+	}
+	return v
+}
+
+// formatFields returns the fields as they are stored, along with the
+// types recorded for them, which is nil if they are all strings.
+// Repeat markers are left out unless withRepeats is set, as ORC and
+// Parquet files keep them in columns of their own.
+func formatFields(fields log.Fields, withRepeats bool) (map[string]string, map[string]string) {
+	strs := make(map[string]string, len(fields))
+	var types map[string]string
+	for k, v := range fields {
+		if !withRepeats && isRepeatMarker(v) {
+			continue
+		}
+		s, t := formatField(v)
+		strs[k] = s
+		if t == "" {
+			continue
+		}
+		if types == nil {
+			types = make(map[string]string)
+		}
+		types[k] = t
+	}
+	return strs, types
+}
@@ -0,0 +1,157 @@
+package apexorc
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/apex/log"
+)
+
+// defaultMaxGroups is how many per-group buffers a
+// FingersCrossedHandler keeps before discarding the oldest.
+const defaultMaxGroups = 1000
+
+// FingersCrossedHandler complies with the github.com/apex/log.Handler
+// interface, holding back entries below a trigger level in memory and
+// only passing them on to another handler, typically a
+// RotatingHandler, when an entry at or above the trigger level
+// arrives.  Verbose entries are thus only persisted when they provide
+// the context of an error.
+//
+// Only the last N entries are kept.  By default there is one buffer
+// for every entry; SetGroupField gives each value of a field, such as
+// a request ID, a buffer of its own, so an error only brings with it
+// the entries that led up to it.
+type FingersCrossedHandler struct {
+	mu        sync.Mutex
+	next      log.Handler
+	trigger   log.Level
+	size      int
+	field     string
+	maxGroups int
+	groups    map[string]*list.Element // groups holds an element of order for each group.
+	order     *list.List               // order lists groups' buffers, oldest first.
+}
+
+// entryBuffer is a ring of the last entries logged for one group.
+type entryBuffer struct {
+	group   string
+	entries []*log.Entry
+	start   int
+}
+
+func (b *entryBuffer) add(e *log.Entry, size int) {
+	if len(b.entries) < size {
+		b.entries = append(b.entries, e)
+		return
+	}
+	b.entries[b.start] = e
+	b.start = (b.start + 1) % size
+}
+
+// NewFingersCrossedHandler returns a FingersCrossedHandler passing
+// entries at or above trigger straight to next, preceded by up to
+// size of the entries below it that were logged before them.
+func NewFingersCrossedHandler(next log.Handler, trigger log.Level, size int) *FingersCrossedHandler {
+	if size < 1 {
+		size = 1
+	}
+	return &FingersCrossedHandler{
+		next:      next,
+		trigger:   trigger,
+		size:      size,
+		maxGroups: defaultMaxGroups,
+		groups:    make(map[string]*list.Element),
+		order:     list.New(),
+	}
+}
+
+// SetGroupField gives each value of the named field a buffer of its
+// own.  Entries without the field share a buffer.  At most maxGroups
+// buffers are kept, after which the one created longest ago is
+// discarded; call Discard when a group is finished with to free its
+// buffer sooner.
+func (h *FingersCrossedHandler) SetGroupField(field string, maxGroups int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if maxGroups < 1 {
+		maxGroups = 1
+	}
+	h.field = field
+	h.maxGroups = maxGroups
+	h.groups = make(map[string]*list.Element)
+	h.order.Init()
+}
+
+// Discard throws away the entries buffered for group, for example
+// once the request it identifies has completed without error.
+func (h *FingersCrossedHandler) Discard(group string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if el, ok := h.groups[group]; ok {
+		h.order.Remove(el)
+		delete(h.groups, group)
+	}
+}
+
+// HandleLog buffers e if it is below the trigger level, and otherwise
+// passes it, along with everything buffered for its group, on to the
+// next handler.
+func (h *FingersCrossedHandler) HandleLog(e *log.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	group := h.groupOf(e)
+	if e.Level < h.trigger {
+		h.buffer(group).add(e, h.size)
+		return nil
+	}
+
+	var err error
+	if el, ok := h.groups[group]; ok {
+		b := el.Value.(*entryBuffer)
+		h.order.Remove(el)
+		delete(h.groups, group)
+		for i := range b.entries {
+			herr := h.next.HandleLog(b.entries[(b.start+i)%len(b.entries)])
+			if err == nil {
+				err = herr
+			}
+		}
+	}
+	if herr := h.next.HandleLog(e); err == nil {
+		err = herr
+	}
+	return err
+}
+
+// groupOf returns the group that e is buffered in.
+func (h *FingersCrossedHandler) groupOf(e *log.Entry) string {
+	if h.field == "" {
+		return ""
+	}
+	v, ok := e.Fields[h.field]
+	if !ok {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// buffer returns the buffer for group, creating it if need be.
+func (h *FingersCrossedHandler) buffer(group string) *entryBuffer {
+	if el, ok := h.groups[group]; ok {
+		return el.Value.(*entryBuffer)
+	}
+	for h.order.Len() >= h.maxGroups {
+		oldest := h.order.Front()
+		h.order.Remove(oldest)
+		delete(h.groups, oldest.Value.(*entryBuffer).group)
+	}
+	b := &entryBuffer{group: group}
+	h.groups[group] = h.order.PushBack(b)
+	return b
+}
@@ -0,0 +1,54 @@
+package apexorc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func TestFingersCrossedHandler(t *testing.T) {
+	rec := &entryRecorder{}
+	h := NewFingersCrossedHandler(rec, log.ErrorLevel, 2)
+
+	for _, msg := range []string{"One", "Two", "Three"} {
+		h.HandleLog(&log.Entry{Level: log.DebugLevel, Message: msg})
+	}
+	if msgs := rec.messages(); len(msgs) != 0 {
+		t.Fatalf("Expected nothing to be passed on yet, got %q", msgs)
+	}
+	h.HandleLog(&log.Entry{Level: log.ErrorLevel, Message: "Boom"})
+	h.HandleLog(&log.Entry{Level: log.FatalLevel, Message: "Bang"})
+
+	expected := []string{"Two", "Three", "Boom", "Bang"}
+	if msgs := rec.messages(); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+}
+
+func TestFingersCrossedHandlerGroups(t *testing.T) {
+	rec := &entryRecorder{}
+	h := NewFingersCrossedHandler(rec, log.WarnLevel, 10)
+	h.SetGroupField("request", 2)
+
+	entry := func(level log.Level, request, msg string) *log.Entry {
+		return &log.Entry{Level: level, Message: msg, Fields: log.Fields{"request": request}}
+	}
+	h.HandleLog(entry(log.InfoLevel, "a", "A1"))
+	h.HandleLog(entry(log.InfoLevel, "b", "B1"))
+	h.HandleLog(entry(log.InfoLevel, "a", "A2"))
+	h.HandleLog(entry(log.WarnLevel, "b", "B2"))
+	// Only the two newest groups are kept, so a is evicted.
+	h.HandleLog(entry(log.InfoLevel, "c", "C1"))
+	h.HandleLog(entry(log.InfoLevel, "d", "D1"))
+	h.HandleLog(entry(log.InfoLevel, "e", "E1"))
+	h.Discard("d")
+	h.HandleLog(entry(log.ErrorLevel, "a", "A3"))
+	h.HandleLog(entry(log.ErrorLevel, "d", "D2"))
+	h.HandleLog(entry(log.ErrorLevel, "e", "E2"))
+
+	expected := []string{"B1", "B2", "A3", "D2", "E1", "E2"}
+	if msgs := rec.messages(); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+}
@@ -0,0 +1,460 @@
+package apexorc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// followPollInterval is how often Follow checks the journal for new
+// entries, or for it having been rotated, once it has read everything.
+const followPollInterval = 250 * time.Millisecond
+
+// Follow passes each entry written to the live journal of the log at
+// path to handler as it is written, in the manner of tail -f, until
+// ctx is done.  Following continues across rotations: when the
+// journal is moved away, Follow finishes reading it and starts on the
+// new one.
+//
+// If backlog is greater than zero Follow starts with the last backlog
+// entries of the latest archive, any journals waiting to be converted
+// and the current journal, otherwise
+// only entries written after Follow is called are handled.  Follow
+// never returns nil; it returns ctx.Err() once ctx is done, or the
+// first error from handler or from reading the journal.
+//
+// There is no journal to follow for a RotatingHandler created with
+// the DirectORC option.
+func Follow(ctx context.Context, path string, backlog int, handler log.Handler) error {
+	return FollowEncrypted(ctx, path, nil, backlog, handler)
+}
+
+// FollowEncrypted is like Follow, but follows a log written by a
+// RotatingHandler created with the Encrypted option, decrypting it
+// with keys from kp.
+func FollowEncrypted(ctx context.Context, path string, kp KeyProvider, backlog int, handler log.Handler) error {
+	return FollowFS(ctx, OSFS{}, path, kp, backlog, handler)
+}
+
+// FollowFS is like FollowEncrypted, but follows a log kept on fsys.
+// kp may be nil if the log isn't encrypted.
+func FollowFS(ctx context.Context, fsys FS, path string, kp KeyProvider, backlog int, handler log.Handler) error {
+	f := &follower{
+		fs:          fsys,
+		path:        path,
+		journalPath: makeJournalPathFromPath(path),
+		stagingDir:  makeStagingDirFromPath(path),
+		handler:     handler,
+		keys:        kp,
+	}
+	return f.follow(ctx, backlog)
+}
+
+// Follow follows the log handled by h.  See the Follow function.
+func (h *RotatingHandler) Follow(ctx context.Context, backlog int, handler log.Handler) error {
+	h.mu.Lock()
+	f := &follower{
+		fs:          h.fs,
+		path:        h.path,
+		journalPath: h.journalPath,
+		stagingDir:  h.stagingDir,
+		handler:     handler,
+		keys:        h.keys,
+	}
+	h.mu.Unlock()
+	return f.follow(ctx, backlog)
+}
+
+func (f *follower) follow(ctx context.Context, backlog int) error {
+	defer f.close()
+
+	if backlog > 0 {
+		err := f.replayBacklog(ctx, backlog)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := f.open(ctx, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		err := f.readAvailable(f.handler.HandleLog)
+		if err != nil {
+			return err
+		}
+		rotated, err := f.rotated()
+		if err != nil {
+			return err
+		}
+		if rotated {
+			// Anything written before the rotation is still
+			// waiting for us in the file we have open.
+			err = f.readAvailable(f.handler.HandleLog)
+			if err != nil {
+				return err
+			}
+			f.close()
+			err = f.open(ctx, false)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(followPollInterval):
+		}
+	}
+}
+
+// follower tracks the journal being followed.
+type follower struct {
+	fs          FS
+	path        string
+	journalPath string
+	stagingDir  string
+	handler     log.Handler
+	keys        KeyProvider
+	file        File
+	offset      int64
+	decoder     envelopeDecoder
+	partial     []byte // partial is an entry still being written.
+}
+
+// open opens the journal, waiting for it to appear if it has just
+// been rotated away.  If atEnd is true, everything already in the
+// journal is skipped.
+func (f *follower) open(ctx context.Context, atEnd bool) error {
+	for {
+		file, err := f.fs.Open(f.journalPath)
+		if err == nil {
+			f.file = file
+			f.reset()
+			if atEnd {
+				err = f.skipToEnd()
+			}
+			return err
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(followPollInterval):
+		}
+	}
+}
+
+// reset starts reading the open journal from the beginning.
+func (f *follower) reset() {
+	f.offset = 0
+	f.decoder = envelopeDecoder{keys: f.keys}
+	f.partial = nil
+}
+
+// skipToEnd skips everything already in the open journal.  An
+// encrypted journal's header is read first, as it holds the key to
+// everything that follows.
+func (f *follower) skipToEnd() error {
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	header := make([]byte, maxEnvelopeHeader)
+	n, err := f.file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.HasPrefix(header[:n], envelopeMagic) {
+		f.offset = info.Size()
+		return nil
+	}
+	_, headerLen, err := parseEnvelopeHeader(header[:n])
+	if err != nil || headerLen == 0 {
+		return err
+	}
+	_, err = f.decoder.feed(header[:headerLen])
+	if err != nil {
+		return err
+	}
+	// The decoder has to know how many frames it skipped, as each is
+	// numbered.
+	f.offset, f.decoder.frames, err = completeFramesEnd(f.file, int64(headerLen), info.Size())
+	return err
+}
+
+func (f *follower) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// replayBacklog hands the last n entries of the latest archive, the
+// journals waiting to be converted and the current journal to the
+// follower's handler, leaving the journal open at its end.  Should
+// the log be rotated while they're read, they are read again, as
+// LogSet does, so nothing is missed or repeated.
+func (f *follower) replayBacklog(ctx context.Context, n int) error {
+	var entries []*log.Entry
+	var err error
+	for attempt := 0; attempt < maxSnapshotAttempts; attempt++ {
+		entries, err = f.readBacklog(n)
+		if err != errSnapshotChanged {
+			break
+		}
+		f.close()
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		err = f.handler.HandleLog(e)
+		if err != nil {
+			return err
+		}
+	}
+	if f.file == nil {
+		return f.open(ctx, false)
+	}
+	return nil
+}
+
+// readBacklog returns the last n entries for replayBacklog, oldest
+// first.  The current journal is opened first, so that should it be
+// rotated away while the rest are read, it isn't also read from the
+// staging directory.  errSnapshotChanged is returned if an archive is
+// added in the meantime, as its journal may have been missed.
+func (f *follower) readBacklog(n int) ([]*log.Entry, error) {
+	ring := make([]*log.Entry, n)
+	var count int
+	keep := func(e *log.Entry) error {
+		ring[count%n] = e
+		count++
+		return nil
+	}
+
+	var current os.FileInfo
+	file, err := f.fs.Open(f.journalPath)
+	if err == nil {
+		f.file = file
+		f.reset()
+		current, err = file.Stat()
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	archives, err := listArchives(f.fs, f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var latest os.FileInfo
+	archived := make(map[string]bool)
+	for _, a := range archives {
+		if a.Manifest != nil && a.Manifest.StagingID != "" {
+			archived[a.Manifest.StagingID] = true
+		}
+	}
+	if len(archives) > 0 {
+		latestPath := archives[len(archives)-1].Path
+		latest, err = f.fs.Stat(latestPath)
+		if err != nil {
+			return nil, errSnapshotChanged
+		}
+		err = replayArchive(f.fs, latestPath, f.keys, keep)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	journals, err := stagedJournals(f.fs, f.stagingDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, journalPath := range journals {
+		err = f.readStaged(journalPath, current, archived, keep)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if f.file != nil {
+		err = f.readAvailable(keep)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	again, err := listArchives(f.fs, f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(again) > 0 {
+		info, err := f.fs.Stat(again[len(again)-1].Path)
+		if err != nil || latest == nil || !sameFile(info, latest) {
+			return nil, errSnapshotChanged
+		}
+	}
+
+	start := 0
+	if count > n {
+		start = count - n
+	}
+	var entries []*log.Entry
+	for i := start; i < count; i++ {
+		entries = append(entries, ring[i%n])
+	}
+	return entries, nil
+}
+
+// readStaged passes the entries of the staged journal at journalPath
+// to fn, unless it is the current journal, which the follower has
+// open, or has already been archived.  Entries already archived in
+// chunks are skipped.
+func (f *follower) readStaged(journalPath string, current os.FileInfo, archived map[string]bool, fn func(*log.Entry) error) error {
+	file, err := f.fs.Open(journalPath)
+	if os.IsNotExist(err) {
+		// Converted since we listed it.
+		return errSnapshotChanged
+	}
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if current != nil && sameFile(info, current) {
+		file.Close()
+		return nil
+	}
+	converted, err := journalArchived(f.fs, journalPath, archived)
+	if err != nil || converted {
+		file.Close()
+		return err
+	}
+	r := newDecryptingReader(file, f.keys)
+	// Part of the journal may already have been archived in chunks.
+	err = skipConverted(f.fs, filepath.Dir(journalPath), f.path, r)
+	if err != nil {
+		r.Close()
+		return err
+	}
+	src := newJournalSource(r)
+	defer src.Close()
+	for {
+		e, err := src.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// replayArchive passes every entry of the ORC file at orcPath to fn,
+// decrypting it with keys if it is encrypted.
+func replayArchive(fsys FS, orcPath string, keys KeyProvider, fn func(*log.Entry) error) error {
+	file, err := fsys.Open(orcPath)
+	if err != nil {
+		return err
+	}
+	src, err := newORCSource(file, keys)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	defer src.Close()
+	for {
+		e, err := src.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(e)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readAvailable passes every complete entry written to the journal
+// since the last call to fn.
+func (f *follower) readAvailable(fn func(*log.Entry) error) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.file.ReadAt(buf, f.offset)
+		f.offset += int64(n)
+		plain, derr := f.decoder.feed(buf[:n])
+		if derr != nil {
+			return derr
+		}
+		f.partial = append(f.partial, plain...)
+		for {
+			i := bytes.IndexByte(f.partial, '\n')
+			if i < 0 {
+				break
+			}
+			line := f.partial[:i]
+			f.partial = f.partial[i+1:]
+			e, derr := decodeJournalEntry(line)
+			if derr != nil {
+				continue
+			}
+			if ferr := fn(e); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// rotated returns true if the journal the follower has open is no
+// longer the one at journalPath.  A journal that has been truncated,
+// as happens to an empty one when a new RotatingHandler starts, is
+// reread from the beginning.
+func (f *follower) rotated() (bool, error) {
+	current, err := f.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	info, err := f.fs.Stat(f.journalPath)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !sameFile(current, info) {
+		return true, nil
+	}
+	if current.Size() < f.offset {
+		f.reset()
+	}
+	return false, nil
+}
@@ -0,0 +1,162 @@
+package apexorc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// Follow should pick up the backlog, then carry on across rotations.
+func TestFollow(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-follow")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, NumericArchiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	log.SetHandler(rotator)
+	log.Info("Too old")
+	log.Info("Archived")
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	log.Info("Journalled")
+
+	followed := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Follow(ctx, path, 2, log.HandlerFunc(func(e *log.Entry) error {
+			followed <- e.Message
+			return nil
+		}))
+	}()
+
+	var msgs []string
+	expect := func(expected ...string) {
+		for len(msgs) < len(expected) {
+			select {
+			case msg := <-followed:
+				msgs = append(msgs, msg)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Timed out waiting for %q, got %q", expected, msgs)
+			}
+		}
+		if !reflect.DeepEqual(msgs, expected) {
+			t.Fatalf("Expected %q, got %q", expected, msgs)
+		}
+	}
+	expect("Archived", "Journalled")
+
+	log.Info("Before rotation")
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	log.Info("After rotation")
+	expect("Archived", "Journalled", "Before rotation", "After rotation")
+
+	cancel()
+	if err = <-done; err != context.Canceled {
+		t.Errorf("Expected context.Canceled from Follow, got %v", err)
+	}
+}
+
+// The backlog should include journals waiting to be converted, so
+// there's no gap right after a rotation.
+func TestFollowBacklogStaged(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	defer rotator.unlock()
+	for _, msg := range []string{"Archived", "Staged", "Journalled"} {
+		if err = rotator.HandleLog(makeTestEntry(msg, nil, nil)); err != nil {
+			t.Fatalf("Error logging: %s", err)
+		}
+		switch msg {
+		case "Archived":
+			err = rotator.Rotate()
+		case "Staged":
+			// A cancelled rotation leaves the journal staged.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if err = rotator.RotateContext(ctx); err == context.Canceled {
+				err = nil
+			}
+		}
+		if err != nil {
+			t.Fatalf("Error rotating: %s", err)
+		}
+	}
+	if staged, _ := listJournalDirs(fsys, rotator.stagingDir); len(staged) != 1 {
+		t.Fatalf("Expected a journal to be left staged, found %v", staged)
+	}
+
+	var msgs []string
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = FollowFS(ctx, fsys, path, nil, 3, log.HandlerFunc(func(e *log.Entry) error {
+		msgs = append(msgs, e.Message)
+		if len(msgs) == 3 {
+			cancel()
+		}
+		return nil
+	}))
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled from FollowFS, got %v", err)
+	}
+	expected := []string{"Archived", "Staged", "Journalled"}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+}
+
+// Following an encrypted journal from its end still needs its header.
+func TestFollowEncryptedFromEnd(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-follow")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	keys := testKeyRing()
+	path := filepath.Join(tmpdir, "testlog.jrnl")
+	h, err := newJournalHandlerForPath(OSFS{}, path, keys)
+	if err != nil {
+		t.Fatalf("Error creating journal: %s", err)
+	}
+	defer h.Close()
+	h.HandleLog(&log.Entry{Message: "Skipped", Level: log.InfoLevel})
+
+	f := &follower{fs: OSFS{}, journalPath: path, keys: keys}
+	if err = f.open(context.Background(), true); err != nil {
+		t.Fatalf("Error opening journal: %s", err)
+	}
+	defer f.close()
+	h.HandleLog(&log.Entry{Message: "Followed", Level: log.InfoLevel})
+
+	var msgs []string
+	err = f.readAvailable(func(e *log.Entry) error {
+		msgs = append(msgs, e.Message)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading journal: %s", err)
+	}
+	if !reflect.DeepEqual(msgs, []string{"Followed"}) {
+		t.Errorf("Expected [\"Followed\"], got %q", msgs)
+	}
+}
@@ -0,0 +1,183 @@
+package apexorc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FS is the storage that log files are written to and read from.  Every
+// component of the package goes through an FS, so logs can be kept
+// somewhere other than the local filesystem, and rotation can be
+// tested without touching disk.  OSFS is used unless another FS is
+// given, with the WithFS option, a SetFS method or the variant of a
+// function whose name ends in FS.  MemFS keeps files in memory.
+//
+// Paths are as understood by path/filepath.  Errors should satisfy
+// os.IsNotExist and os.IsExist where the os package's would.
+type FS interface {
+	// Create creates or truncates the named file, opening it for
+	// reading and writing.
+	Create(name string) (File, error)
+	// Open opens the named file for reading.
+	Open(name string) (File, error)
+	// OpenAppend opens the named file for reading and appending,
+	// creating it if it doesn't exist.
+	OpenAppend(name string) (File, error)
+	// Rename moves a file or directory, replacing any file at
+	// newpath.
+	Rename(oldpath, newpath string) error
+	// Remove removes a file or an empty directory.
+	Remove(name string) error
+	// RemoveAll removes a file or directory and everything in it,
+	// and doesn't fail if there is nothing to remove.
+	RemoveAll(path string) error
+	// Stat describes the named file or directory.
+	Stat(name string) (os.FileInfo, error)
+	// ReadDir lists a directory, sorted by name.
+	ReadDir(name string) ([]os.FileInfo, error)
+	// Mkdir creates a directory, failing if it already exists.
+	Mkdir(name string, perm os.FileMode) error
+	// MkdirAll creates a directory and any parents it needs.
+	MkdirAll(path string, perm os.FileMode) error
+}
+
+// File is a file opened through an FS.  Closing a File a second time
+// returns an error satisfying errors.Is(err, os.ErrClosed).
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OSFS is an FS on the local filesystem, using the os package.
+type OSFS struct{}
+
+// Create is os.Create.
+func (OSFS) Create(name string) (File, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Open is os.Open.
+func (OSFS) Open(name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// OpenAppend opens name with os.O_RDWR, os.O_CREATE and os.O_APPEND.
+func (OSFS) OpenAppend(name string) (File, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Rename is os.Rename.
+func (OSFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// Remove is os.Remove.
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// RemoveAll is os.RemoveAll.
+func (OSFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+// Stat is os.Stat.
+func (OSFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// ReadDir is ioutil.ReadDir.
+func (OSFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+// Mkdir is os.Mkdir.
+func (OSFS) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+// MkdirAll is os.MkdirAll.
+func (OSFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// readFile reads the whole of the named file from fsys.
+func readFile(fsys FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// writeFile writes data to the named file on fsys, replacing anything
+// already there, with the permissions perm where the FS has them.
+func writeFile(fsys FS, name string, data []byte, perm os.FileMode) error {
+	f, err := fsys.Create(name)
+	if err != nil {
+		return err
+	}
+	if c, ok := f.(interface{ Chmod(os.FileMode) error }); ok {
+		err = c.Chmod(perm)
+	}
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// tempDir creates a new directory in dir, with a name beginning with
+// prefix, in the manner of ioutil.TempDir.
+func tempDir(fsys FS, dir, prefix string) (string, error) {
+	b := make([]byte, 6)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		name := filepath.Join(dir, prefix+hex.EncodeToString(b))
+		err := fsys.Mkdir(name, 0700)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+}
+
+// sameFile reports whether a and b, returned by the Stat method of an
+// FS or File, describe the same file.
+func sameFile(a, b os.FileInfo) bool {
+	if ma, ok := a.(*memFileInfo); ok {
+		mb, ok := b.(*memFileInfo)
+		return ok && ma.node == mb.node
+	}
+	return os.SameFile(a, b)
+}
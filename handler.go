@@ -3,7 +3,7 @@
 package apexorc
 
 import (
-	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/apex/log"
@@ -15,55 +15,122 @@ import (
 type Handler struct {
 	mu     sync.Mutex
 	path   string
+	file   *outputFile
 	writer *orc.Writer
+	stats  entryStats // stats describes the entries in the current, or last, file.
+
+	redactor *Redactor
+	keys     KeyProvider
+	fs       FS
 }
 
 // NewHandler returns a Handler which can log to an ORC file at the
-// provided path.
+// provided path.  Entries are written to a temporary file in the same
+// directory, which is only renamed to the provided path once it has
+// been closed and synced, so a file at that path is always complete.
 func NewHandler(path string) *Handler {
 	return &Handler{
 		path: path,
+		fs:   OSFS{},
 	}
 }
 
 func (h *Handler) openORCFile() error {
-	f, err := os.Create(h.path)
+	f, err := createOutputFile(h.fs, h.path, h.keys)
 	if err != nil {
 		return err
 	}
-	w, err := newWriter(f)
+	w, err := newWriter(f.writer())
 	if err != nil {
+		f.discard()
 		return err
 	}
+	h.file = f
 	h.writer = w
+	h.stats = entryStats{}
 	return nil
 }
 
+// closeORCFile finalises and publishes the current file.  Either way,
+// the next entry starts a new file; one that couldn't be finalised or
+// published is set aside rather than being overwritten by it.
 func (h *Handler) closeORCFile() error {
 	// If we never call HandleLog then there'll be no writer.
+	var err error
 	if h.writer != nil {
-		err := h.writer.Close()
-		if err != nil {
-			return err
-		}
+		err = h.writer.Close()
 		h.writer = nil
 	}
+	if h.file == nil {
+		return err
+	}
+	f := h.file
+	h.file = nil
+	if err == nil {
+		err = f.publish(h.path)
+	}
+	if err != nil {
+		return f.setAside(h.path, err)
+	}
 	return nil
 }
 
+// makeTempPathFromPath returns the path of the hidden file, in the
+// same directory as srcPath, that an ORC file is written to before
+// being renamed to srcPath.  Keeping it in the same directory means
+// the rename can never cross devices, and so is atomic.
+func makeTempPathFromPath(srcPath string) string {
+	dir, file := filepath.Split(srcPath)
+	return filepath.Join(dir, "."+file+".tmp")
+}
+
+// SetRedactor sets a Redactor to be applied to every entry before it
+// is written.
+func (h *Handler) SetRedactor(r *Redactor) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.redactor = r
+}
+
+// SetFS makes the Handler write its files to fsys, starting with the
+// next file it opens.  See FS.
+func (h *Handler) SetFS(fsys FS) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fs = fsys
+}
+
+// SetKeyProvider makes the Handler encrypt the ORC files it writes
+// with keys from kp, starting with the next file it opens.  See
+// KeyProvider.
+func (h *Handler) SetKeyProvider(kp KeyProvider) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.keys = kp
+}
+
 // HandleLog recieves new log.Entrys and writes them to an ORC file or
 // errors, as specified by the github.com/apex/log.Handler intefrace.
 func (h *Handler) HandleLog(e *log.Entry) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if h.redactor != nil {
+		e = h.redactor.Redact(e)
+	}
+
 	if h.writer == nil {
 		err := h.openORCFile()
 		if err != nil {
 			return err
 		}
 	}
-	return writeRecord(h.writer, e)
+	err := writeRecord(h.writer, e)
+	if err != nil {
+		return err
+	}
+	h.stats.add(e)
+	return nil
 }
 
 // Close finalises the underlying ORC file.
@@ -72,3 +139,28 @@ func (h *Handler) Close() error {
 	defer h.mu.Unlock()
 	return h.closeORCFile()
 }
+
+// discard abandons the current file, removing it rather than
+// publishing it.
+func (h *Handler) discard() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.writer != nil {
+		h.writer.Close()
+		h.writer = nil
+	}
+	if h.file != nil {
+		h.file.discard()
+		h.file = nil
+	}
+}
+
+func (h *Handler) filePath() string {
+	return h.path
+}
+
+func (h *Handler) entryStats() entryStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
@@ -3,13 +3,49 @@ package apexorc
 import (
 	"bytes"
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/apex/log"
 	"github.com/scritchley/orc"
 )
 
+// entryRecorder records the entries it handles, for testing the
+// handlers that pass entries on to another.  It may be used from a
+// timer.
+type entryRecorder struct {
+	mu      sync.Mutex
+	entries []*log.Entry
+}
+
+func (r *entryRecorder) HandleLog(e *log.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+// recorded returns the entries handled so far.
+func (r *entryRecorder) recorded() []*log.Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*log.Entry(nil), r.entries...)
+}
+
+// messages returns the messages of the entries handled so far.
+func (r *entryRecorder) messages() []string {
+	var msgs []string
+	for _, e := range r.recorded() {
+		msgs = append(msgs, e.Message)
+	}
+	return msgs
+}
+
 func TestHandleLog(t *testing.T) {
 	buff := bytes.NewBuffer([]byte{})
 	w, err := newWriter(buff)
@@ -38,7 +74,7 @@ func TestHandleLog(t *testing.T) {
 	}
 	td := r.Schema()
 	columns := td.Columns()
-	expectedColumns := []string{"timestamp", "level", "message", "fields"}
+	expectedColumns := []string{"timestamp", "level", "message", "fields", "repeat_count", "last_timestamp", "field_types"}
 	if !reflect.DeepEqual(expectedColumns, columns) {
 		t.Fatalf("Expected columns %q, got %q", expectedColumns, columns)
 	}
@@ -67,3 +103,163 @@ func TestCloseOnUnusedHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// Nothing should appear at the Handler's path until the ORC file is
+// complete, so that readers never see a file without a footer.
+func TestHandlerPublishesOnClose(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-handler")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	handler := NewHandler(path)
+	log.SetHandler(handler)
+	log.Info("Half written")
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing at %q before Close", path)
+	}
+	tmpPath := makeTempPathFromPath(path)
+	if _, err := os.Stat(tmpPath); err != nil {
+		t.Fatalf("Expected in-progress file at %q: %s", tmpPath, err)
+	}
+
+	err = handler.Close()
+	if err != nil {
+		t.Fatalf("Error closing handler: %s", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected ORC file at %q after Close: %s", path, err)
+	}
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Errorf("Expected %q to be renamed away on Close", tmpPath)
+	}
+}
+
+func TestMakeTempPathFromPath(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{"/home/baron/log.orc", "/home/baron/.log.orc.tmp"},
+		{"log.orc", ".log.orc.tmp"},
+	}
+	for cid, tcase := range cases {
+		tmpPath := makeTempPathFromPath(tcase.Input)
+		if tmpPath != tcase.Expected {
+			t.Errorf("[Case %d] Got %q, expected %q", cid, tmpPath, tcase.Expected)
+		}
+	}
+}
+
+// syncCheckingFS records the files on it that were synced while still
+// open.
+type syncCheckingFS struct {
+	*MemFS
+	synced map[string]bool
+}
+
+func (fsys *syncCheckingFS) Create(name string) (File, error) {
+	f, err := fsys.MemFS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &syncCheckingFile{File: f, fsys: fsys}, nil
+}
+
+type syncCheckingFile struct {
+	File
+	fsys *syncCheckingFS
+}
+
+func (f *syncCheckingFile) Sync() error {
+	err := f.File.Sync()
+	if err == nil {
+		f.fsys.synced[f.Name()] = true
+	}
+	return err
+}
+
+// Files are synced, while they are still open, before being renamed
+// into place.
+func TestHandlerSyncsBeforePublishing(t *testing.T) {
+	fsys := &syncCheckingFS{MemFS: NewMemFS(), synced: make(map[string]bool)}
+	for _, h := range []fileHandler{NewHandler("/testlog.orc"), NewParquetHandler("/testlog.parquet")} {
+		switch h := h.(type) {
+		case *Handler:
+			h.SetFS(fsys)
+		case *ParquetHandler:
+			h.SetFS(fsys)
+		}
+		if err := h.HandleLog(makeTestEntry("Synced", nil, nil)); err != nil {
+			t.Fatalf("Error logging: %s", err)
+		}
+		if err := h.Close(); err != nil {
+			t.Fatalf("Error closing: %s", err)
+		}
+		if !fsys.synced[makeTempPathFromPath(h.filePath())] {
+			t.Errorf("Expected %s to be synced before it was published", h.filePath())
+		}
+	}
+}
+
+// errRenameFailed is the error renameFailingFS fails renames with.
+var errRenameFailed = errors.New("Not today")
+
+// renameFailingFS fails renames to path while fail is set.
+type renameFailingFS struct {
+	*MemFS
+	path string
+	fail bool
+}
+
+func (fsys *renameFailingFS) Rename(oldpath, newpath string) error {
+	if fsys.fail && newpath == fsys.path {
+		return errRenameFailed
+	}
+	return fsys.MemFS.Rename(oldpath, newpath)
+}
+
+// A file that can't be published is set aside, where the next file
+// can't overwrite it, and the handler carries on with a new file.
+func TestHandlerSetsAsideUnpublishedFile(t *testing.T) {
+	fsys := &renameFailingFS{MemFS: NewMemFS(), path: "/testlog.orc", fail: true}
+	handler := NewHandler("/testlog.orc")
+	handler.SetFS(fsys)
+	if err := handler.HandleLog(makeTestEntry("Kept", nil, nil)); err != nil {
+		t.Fatalf("Error logging: %s", err)
+	}
+	if err := handler.Close(); !errors.Is(err, errRenameFailed) {
+		t.Fatalf("Expected the failed rename from closing, got %v", err)
+	}
+
+	fsys.fail = false
+	if err := handler.HandleLog(makeTestEntry("Next", nil, nil)); err != nil {
+		t.Fatalf("Error logging: %s", err)
+	}
+	if err := handler.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	if msgs := testArchiveMessages(t, fsys, "/testlog.orc"); !reflect.DeepEqual(msgs, []string{"Next"}) {
+		t.Errorf("Expected [Next], got %q", msgs)
+	}
+
+	infos, err := fsys.ReadDir("/")
+	if err != nil {
+		t.Fatalf("Error reading directory: %s", err)
+	}
+	var aside []string
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".testlog.orc.failed-") {
+			aside = append(aside, "/"+info.Name())
+		}
+	}
+	if len(aside) != 1 {
+		t.Fatalf("Expected one file set aside, found %v", aside)
+	}
+	if msgs := testArchiveMessages(t, fsys, aside[0]); !reflect.DeepEqual(msgs, []string{"Kept"}) {
+		t.Errorf("Expected [Kept] in the file set aside, got %q", msgs)
+	}
+}
@@ -3,7 +3,6 @@ package apexorc
 import (
 	"encoding/json"
 	"io"
-	"os"
 	"path"
 	"sync"
 
@@ -17,22 +16,103 @@ type journalHandler struct {
 	writer io.Writer
 }
 
+// journalLine is the form in which a log.Entry is written to a
+// journal.  Fields whose values aren't strings are written as they
+// would be stored in an ORC file, and FieldTypes records their types,
+// as JSON wouldn't bring them back as they were.
+type journalLine struct {
+	*log.Entry
+	FieldTypes map[string]string `json:"field_types,omitempty"`
+}
+
+// newJournalLine returns the journalLine for e.
+func newJournalLine(e *log.Entry) journalLine {
+	strs, types := formatFields(e.Fields, true)
+	if types == nil {
+		return journalLine{Entry: e}
+	}
+	c := *e
+	c.Fields = make(log.Fields, len(strs))
+	for k, s := range strs {
+		c.Fields[k] = s
+	}
+	return journalLine{Entry: &c, FieldTypes: types}
+}
+
+// decodeJournalEntry decodes a line of a journal written by a
+// journalHandler.
+func decodeJournalEntry(line []byte) (*log.Entry, error) {
+	l := journalLine{Entry: &log.Entry{}}
+	err := json.Unmarshal(line, &l)
+	if err != nil {
+		return nil, err
+	}
+	e := l.Entry
+	for k, t := range l.FieldTypes {
+		if s, ok := e.Fields[k].(string); ok {
+			e.Fields[k] = parseField(s, t)
+		}
+	}
+	return e, nil
+}
+
 func newJournalHandler(w io.Writer) *journalHandler {
 	return &journalHandler{writer: w}
 }
 
-func newJournalHandlerForPath(path string) (*journalHandler, error) {
-	f, err := os.Create(path)
+// newJournalHandlerForPath creates a journal at path on fsys.  If keys
+// isn't nil the journal is encrypted, with each entry sealed in a
+// frame of its own.
+func newJournalHandlerForPath(fsys FS, path string, keys KeyProvider) (*journalHandler, error) {
+	f, err := fsys.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return &journalHandler{writer: f}, nil
+	}
+	w, err := newEnvelopeWriter(f, keys, false)
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
-	return &journalHandler{writer: f}, nil
+	return &journalHandler{writer: w}, nil
+}
+
+// openJournalHandlerForPath is like newJournalHandlerForPath, but
+// appends to any existing journal at path rather than truncating it.
+// An existing encrypted journal carries on using the data key in its
+// header.
+func openJournalHandlerForPath(fsys FS, path string, keys KeyProvider) (*journalHandler, error) {
+	f, err := fsys.OpenAppend(path)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return &journalHandler{writer: f}, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var w *envelopeWriter
+	if info.Size() == 0 {
+		w, err = newEnvelopeWriter(f, keys, false)
+	} else {
+		w, err = resumeEnvelopeWriter(f, keys)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &journalHandler{writer: w}, nil
 }
 
 func (h *journalHandler) HandleLog(e *log.Entry) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	b, err := json.Marshal(e)
+	b, err := json.Marshal(newJournalLine(e))
 	if err != nil {
 		return err
 	}
@@ -60,3 +140,20 @@ func makeJournalPathFromPath(srcPath string) string {
 	extent := len(srcPath) - len(ext)
 	return srcPath[:extent] + ".jrnl"
 }
+
+// makeStagingDirFromPath returns the default directory, alongside the
+// journal, in which rotated journals wait to be converted to ORC.
+func makeStagingDirFromPath(srcPath string) string {
+	dir, file := path.Split(srcPath)
+	ext := path.Ext(file)
+	return path.Join(dir, "."+file[:len(file)-len(ext)]+".staging")
+}
+
+// makeQuarantineDirFromPath returns the default directory, alongside
+// the journal, to which journals that repeatedly failed conversion
+// are moved.
+func makeQuarantineDirFromPath(srcPath string) string {
+	dir, file := path.Split(srcPath)
+	ext := path.Ext(file)
+	return path.Join(dir, "."+file[:len(file)-len(ext)]+".quarantine")
+}
@@ -53,3 +53,36 @@ func TestMakeJournalPathFromPath(t *testing.T) {
 		}
 	}
 }
+
+func TestMakeStagingDirFromPath(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{"/home/baron/log.orc", "/home/baron/.log.staging"},
+		{"/home/baron/log", "/home/baron/.log.staging"},
+		{"foo.xxes", ".foo.staging"},
+	}
+	for cid, tcase := range cases {
+		stagingDir := makeStagingDirFromPath(tcase.Input)
+		if stagingDir != tcase.Expected {
+			t.Errorf("[Case %d] Got %q, expected %q", cid, stagingDir, tcase.Expected)
+		}
+	}
+}
+
+func TestMakeQuarantineDirFromPath(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{"/home/baron/log.orc", "/home/baron/.log.quarantine"},
+		{"foo.xxes", ".foo.quarantine"},
+	}
+	for cid, tcase := range cases {
+		quarantineDir := makeQuarantineDirFromPath(tcase.Input)
+		if quarantineDir != tcase.Expected {
+			t.Errorf("[Case %d] Got %q, expected %q", cid, quarantineDir, tcase.Expected)
+		}
+	}
+}
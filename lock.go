@@ -0,0 +1,149 @@
+package apexorc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LockedError is returned by NewRotatingHandler when another
+// RotatingHandler, usually in another process, already holds the lock
+// on the log at Path.  Two handlers sharing a log would truncate and
+// interleave each other's journals.  PID is the process ID recorded in
+// the lock file by its holder, or 0 if it couldn't be read.
+//
+// You can check an error to see if it is a LockedError by passing it
+// to IsLockedError.
+type LockedError struct {
+	Path     string
+	LockPath string
+	PID      int
+}
+
+func (e LockedError) Error() string {
+	if e.PID != 0 {
+		return fmt.Sprintf("apexorc: log %s is in use by process %d (lock file %s)", e.Path, e.PID, e.LockPath)
+	}
+	return fmt.Sprintf("apexorc: log %s is in use by another process (lock file %s)", e.Path, e.LockPath)
+}
+
+// IsLockedError returns true if the error passed to it is a
+// LockedError.
+func IsLockedError(e error) bool {
+	_, ok := e.(LockedError)
+	return ok
+}
+
+// errLockHeld is returned by a lockingFS when the lock is already held.
+var errLockHeld = errors.New("apexorc: lock is held")
+
+// lockingFS is implemented by an FS that can take an exclusive
+// advisory lock on a file.  Lock creates the file if need be, and
+// returns errLockHeld without waiting if the lock is held elsewhere.
+// The lock is released by closing the returned File, which is open
+// for writing.  An FS that doesn't implement lockingFS isn't locked.
+type lockingFS interface {
+	Lock(name string) (File, error)
+}
+
+// PIDSuffixOnLock is an Option that, rather than failing with a
+// LockedError when another process holds the log's lock, makes the
+// RotatingHandler fall back to a log of its own, with the process ID
+// inserted before the path's extension, so "mylog.orc" becomes
+// "mylog.1234.orc".
+func PIDSuffixOnLock() Option {
+	return func(h *RotatingHandler) {
+		h.pidSuffixOnLock = true
+	}
+}
+
+// makeLockPathFromPath returns the path of the hidden lock file,
+// alongside the journal, that a RotatingHandler holds for as long as
+// it logs to srcPath.
+func makeLockPathFromPath(srcPath string) string {
+	dir, file := filepath.Split(srcPath)
+	ext := filepath.Ext(file)
+	return filepath.Join(dir, "."+file[:len(file)-len(ext)]+".lock")
+}
+
+// makePIDPathFromPath inserts pid before the extension of srcPath.
+func makePIDPathFromPath(srcPath string, pid int) string {
+	ext := filepath.Ext(srcPath)
+	return srcPath[:len(srcPath)-len(ext)] + "." + strconv.Itoa(pid) + ext
+}
+
+// lockLog takes the lock on the log at path, if fsys supports locking,
+// recording our process ID in the lock file.  A nil File is returned
+// if it doesn't.
+func lockLog(fsys FS, path string) (File, error) {
+	lfs, ok := fsys.(lockingFS)
+	if !ok {
+		return nil, nil
+	}
+	lockPath := makeLockPathFromPath(path)
+	f, err := lfs.Lock(lockPath)
+	if err == errLockHeld {
+		lerr := LockedError{Path: path, LockPath: lockPath}
+		if b, err := readFile(fsys, lockPath); err == nil {
+			lerr.PID, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+		}
+		return nil, lerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.Write([]byte(strconv.Itoa(os.Getpid()) + "\n"))
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Lock takes an exclusive lock on the named file, which only other
+// holders of a lock from the same MemFS will see.
+func (m *MemFS) Lock(name string) (File, error) {
+	m.mu.Lock()
+	if m.locks == nil {
+		m.locks = make(map[string]bool)
+	}
+	if m.locks[name] {
+		m.mu.Unlock()
+		return nil, errLockHeld
+	}
+	m.locks[name] = true
+	m.mu.Unlock()
+
+	f, err := m.OpenAppend(name)
+	if err != nil {
+		m.unlock(name)
+		return nil, err
+	}
+	return &memLockFile{File: f, unlock: func() { m.unlock(name) }}, nil
+}
+
+func (m *MemFS) unlock(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.locks, name)
+}
+
+// memLockFile releases a MemFS lock when it is closed.
+type memLockFile struct {
+	File
+	once   sync.Once
+	unlock func()
+}
+
+func (f *memLockFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(f.unlock)
+	return err
+}
@@ -0,0 +1,143 @@
+package apexorc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A second RotatingHandler for the same log is refused while the
+// first holds the lock, and may take it once the lock is released.
+func TestRotatingHandlerLock(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-lock")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "testlog.orc")
+
+	first, err := NewRotatingHandler(path, NumericArchiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	_, err = NewRotatingHandler(path, NumericArchiveF)
+	if !IsLockedError(err) {
+		t.Fatalf("Expected a LockedError, got %v", err)
+	}
+	lerr := err.(LockedError)
+	if lerr.PID != os.Getpid() {
+		t.Errorf("Expected the lock to be held by %d, got %d", os.Getpid(), lerr.PID)
+	}
+	if lerr.LockPath != filepath.Join(tmpdir, ".testlog.lock") {
+		t.Errorf("Unexpected lock path %q", lerr.LockPath)
+	}
+
+	first.Close(context.Background())
+	second, err := NewRotatingHandler(path, NumericArchiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler after unlocking: %s", err)
+	}
+	second.Close(context.Background())
+}
+
+// A handler that fails to start releases the lock it took.
+func TestRotatingHandlerUnlocksOnError(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	journalPath := makeJournalPathFromPath(path)
+	if err := fsys.Mkdir(journalPath, 0755); err != nil {
+		t.Fatalf("Error making directory: %s", err)
+	}
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err == nil || rotator != nil {
+		t.Fatalf("Expected an error opening the journal, got %v", err)
+	}
+
+	if err = fsys.Remove(journalPath); err != nil {
+		t.Fatalf("Error removing directory: %s", err)
+	}
+	rotator, err = NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler after a failure: %s", err)
+	}
+	rotator.Close(context.Background())
+}
+
+// A journal left behind by a handler that was never closed, as when
+// its process crashed, is kept and converted by the next handler.
+func TestRotatingHandlerLeftoverJournal(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	crashed, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	testLogChunkEntries(t, crashed, []string{"Before"})
+	crashed.unlock()
+
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	testLogChunkEntries(t, rotator, []string{"After"})
+	expected := []string{"Before", "After"}
+	set, err := rotator.OpenLogSet(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Error opening log set: %s", err)
+	}
+	if msgs := testReadLogSet(t, set); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q from the log set, got %q", expected, msgs)
+	}
+	set.Close()
+
+	if err = rotator.Close(context.Background()); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	if msgs, _ := testArchivedMessages(t, fsys, path); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q to be archived, got %q", expected, msgs)
+	}
+}
+
+// With PIDSuffixOnLock a handler that can't take the lock logs to a
+// path of its own instead.
+func TestPIDSuffixOnLock(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	first, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	defer first.Close(context.Background())
+	second, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys), PIDSuffixOnLock())
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	defer second.Close(context.Background())
+
+	expected := makePIDPathFromPath(path, os.Getpid())
+	if second.path != expected {
+		t.Errorf("Expected path %q, got %q", expected, second.path)
+	}
+	if _, err := fsys.Stat(makeJournalPathFromPath(expected)); err != nil {
+		t.Errorf("Expected a journal for %q: %s", expected, err)
+	}
+
+	// There's nowhere left to fall back to.
+	_, err = NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys), PIDSuffixOnLock())
+	if !IsLockedError(err) {
+		t.Errorf("Expected a LockedError, got %v", err)
+	}
+}
+
+func TestMakePIDPathFromPath(t *testing.T) {
+	if p := makePIDPathFromPath("/var/log/mylog.orc", 1234); p != "/var/log/mylog.1234.orc" {
+		t.Errorf("Got %q", p)
+	}
+	if p := makePIDPathFromPath("mylog", 1234); p != "mylog.1234" {
+		t.Errorf("Got %q", p)
+	}
+}
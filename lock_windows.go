@@ -0,0 +1,38 @@
+//go:build windows
+
+package apexorc
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// Lock takes an exclusive LockFileEx lock on the first byte of the
+// named file.  The lock is released by the system if the process dies.
+func (OSFS) Lock(name string) (File, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	var ol syscall.Overlapped
+	r, _, errno := procLockFileEx.Call(f.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0,
+		uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		f.Close()
+		if errno == errorLockViolation || errno == syscall.ERROR_IO_PENDING {
+			return nil, errLockHeld
+		}
+		return nil, &os.PathError{Op: "LockFileEx", Path: name, Err: errno}
+	}
+	return f, nil
+}
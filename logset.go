@@ -0,0 +1,389 @@
+package apexorc
+
+import (
+	"container/heap"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// maxSnapshotAttempts limits how many times OpenLogSet will try to
+// open a consistent set of files before giving up.
+const maxSnapshotAttempts = 5
+
+// errSnapshotChanged is returned by OpenLogSet when the log kept
+// rotating while it tried to open it.
+var errSnapshotChanged = errors.New("apexorc: log rotated repeatedly while opening its files")
+
+// logPaths locates all of the files that make up a log, along with
+// the keys needed to read them if they are encrypted.
+type logPaths struct {
+	fs            FS
+	path          string
+	journalPath   string
+	stagingDir    string
+	quarantineDir string
+	direct        bool
+	keys          KeyProvider
+}
+
+func defaultLogPaths(fsys FS, path string) logPaths {
+	return logPaths{
+		fs:            fsys,
+		path:          path,
+		journalPath:   makeJournalPathFromPath(path),
+		stagingDir:    makeStagingDirFromPath(path),
+		quarantineDir: makeQuarantineDirFromPath(path),
+	}
+}
+
+// LogSet reads every entry of a log handled by a RotatingHandler as a
+// single chronologically ordered stream: its ORC archives, any
+// journals that are quarantined or waiting to be converted, and the
+// live journal.  Entries in the live journal of a RotatingHandler
+// created with the DirectORC option can't be read until the handler
+// is rotated.
+//
+// The files are all opened by OpenLogSet, so rotation while reading
+// neither loses nor repeats entries; entries logged after OpenLogSet
+// returns are not included.  Use it like an orc.Cursor:
+//
+//	set, err := apexorc.OpenLogSet("mylog.orc", time.Now().Add(-2*time.Hour), time.Time{})
+//	...
+//	defer set.Close()
+//	for set.Next() {
+//		e := set.Entry()
+//		...
+//	}
+//	err = set.Err()
+type LogSet struct {
+	from    time.Time
+	to      time.Time
+	sources []entrySource
+	heads   sourceHeap
+	entry   *log.Entry
+	err     error
+}
+
+// OpenLogSet opens a LogSet over the log at path, which must be the
+// path passed to NewRotatingHandler.  Only entries timestamped between
+// from and to, inclusive, are read; a zero from or to leaves that end
+// of the range open.  The default staging and quarantine directories
+// are assumed; use RotatingHandler.OpenLogSet if they were changed.
+func OpenLogSet(path string, from, to time.Time) (*LogSet, error) {
+	return openLogSet(defaultLogPaths(OSFS{}, path), from, to)
+}
+
+// OpenEncryptedLogSet is like OpenLogSet, but reads a log written by a
+// RotatingHandler created with the Encrypted option, decrypting it
+// with keys from kp.
+func OpenEncryptedLogSet(path string, kp KeyProvider, from, to time.Time) (*LogSet, error) {
+	return OpenLogSetFS(OSFS{}, path, kp, from, to)
+}
+
+// OpenLogSetFS is like OpenEncryptedLogSet, but reads a log kept on
+// fsys.  kp may be nil if the log isn't encrypted.
+func OpenLogSetFS(fsys FS, path string, kp KeyProvider, from, to time.Time) (*LogSet, error) {
+	paths := defaultLogPaths(fsys, path)
+	paths.keys = kp
+	return openLogSet(paths, from, to)
+}
+
+// OpenLogSet opens a LogSet over the log handled by h.  See the
+// OpenLogSet function.
+func (h *RotatingHandler) OpenLogSet(from, to time.Time) (*LogSet, error) {
+	h.mu.Lock()
+	paths := logPaths{
+		fs:            h.fs,
+		path:          h.path,
+		journalPath:   h.journalPath,
+		stagingDir:    h.stagingDir,
+		quarantineDir: h.quarantineDir,
+		direct:        h.direct,
+		keys:          h.keys,
+	}
+	h.mu.Unlock()
+	return openLogSet(paths, from, to)
+}
+
+func openLogSet(paths logPaths, from, to time.Time) (*LogSet, error) {
+	for attempt := 0; attempt < maxSnapshotAttempts; attempt++ {
+		sources, err := openSnapshot(paths, from, to)
+		if err == errSnapshotChanged {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		s := &LogSet{from: from, to: to, sources: sources}
+		for i, src := range sources {
+			s.push(i, src)
+		}
+		return s, nil
+	}
+	return nil, errSnapshotChanged
+}
+
+// snapshotFile is a file listed as part of a log.
+type snapshotFile struct {
+	path    string
+	info    os.FileInfo
+	archive *Archive // archive is nil for journals.
+}
+
+// listSnapshot lists the files that make up a log in the order they
+// should be read: oldest archive first, live journal last.
+func listSnapshot(paths logPaths) ([]snapshotFile, error) {
+	var files []snapshotFile
+	archives, err := listArchives(paths.fs, paths.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	archived := make(map[string]bool)
+	for i := range archives {
+		files = append(files, snapshotFile{path: archives[i].Path, archive: &archives[i]})
+		if m := archives[i].Manifest; m != nil && m.StagingID != "" {
+			archived[m.StagingID] = true
+		}
+	}
+	for _, dir := range []string{paths.quarantineDir, paths.stagingDir} {
+		journals, err := listJournalDirs(paths.fs, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, journalPath := range journals {
+			converted, err := journalArchived(paths.fs, journalPath, archived)
+			if err != nil {
+				return nil, err
+			}
+			if !converted {
+				files = append(files, snapshotFile{path: journalPath})
+			}
+		}
+	}
+	if !paths.direct {
+		files = append(files, snapshotFile{path: paths.journalPath})
+	}
+
+	listed := files[:0]
+	for _, f := range files {
+		info, err := paths.fs.Stat(f.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		f.info = info
+		listed = append(listed, f)
+	}
+	return listed, nil
+}
+
+// journalArchived reports whether the staged journal at journalPath
+// has been converted in full, and is only waiting to be removed, given
+// the StagingIDs of the archives found.  The entries of a journal
+// converted in chunks are instead skipped as the chunks are archived;
+// see skipConverted.
+func journalArchived(fsys FS, journalPath string, archived map[string]bool) (bool, error) {
+	if !archived[readRotationInfo(fsys, journalPath).StagingID] {
+		return false, nil
+	}
+	_, err := fsys.Stat(filepath.Join(filepath.Dir(journalPath), checkpointName))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	return false, err
+}
+
+// listJournalDirs returns the paths of the rotated journals in a
+// staging or quarantine directory.
+func listJournalDirs(fsys FS, dir string) ([]string, error) {
+	infos, err := fsys.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var journals []string
+	for _, info := range infos {
+		if info.IsDir() {
+			journals = append(journals, filepath.Join(dir, info.Name(), workingJournalName))
+		}
+	}
+	return journals, nil
+}
+
+// openSnapshot opens the files of a log that might hold entries
+// between from and to.  Should any file move while they're being
+// opened, everything is closed again and errSnapshotChanged returned.
+func openSnapshot(paths logPaths, from, to time.Time) ([]entrySource, error) {
+	files, err := listSnapshot(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []entrySource
+	closeAll := func() {
+		for _, src := range sources {
+			src.Close()
+		}
+	}
+	for _, sf := range files {
+		if sf.archive != nil && !sf.archive.Covers(from, to) {
+			continue
+		}
+		f, err := paths.fs.Open(sf.path)
+		if os.IsNotExist(err) {
+			closeAll()
+			return nil, errSnapshotChanged
+		}
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil || !sameFile(info, sf.info) {
+			f.Close()
+			closeAll()
+			if err != nil {
+				return nil, err
+			}
+			return nil, errSnapshotChanged
+		}
+		if sf.archive == nil {
+			r := newDecryptingReader(f, paths.keys)
+			if sf.path != paths.journalPath {
+				// Part of a rotated journal may already
+				// have been archived in chunks.
+				err = skipConverted(paths.fs, filepath.Dir(sf.path), paths.path, r)
+				if err != nil {
+					r.Close()
+					closeAll()
+					return nil, err
+				}
+			}
+			sources = append(sources, newJournalSource(r))
+			continue
+		}
+		src, err := newORCSource(f, paths.keys)
+		if err != nil {
+			f.Close()
+			closeAll()
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+
+	// If nothing moved while we were opening files then we have a
+	// consistent view of the log.
+	again, err := listSnapshot(paths)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	if len(again) != len(files) {
+		closeAll()
+		return nil, errSnapshotChanged
+	}
+	for i := range files {
+		if again[i].path != files[i].path || !sameFile(again[i].info, files[i].info) {
+			closeAll()
+			return nil, errSnapshotChanged
+		}
+	}
+	return sources, nil
+}
+
+// push reads the next entry in range from the source at index i onto
+// the heap of pending entries.
+func (s *LogSet) push(i int, src entrySource) {
+	for {
+		e, err := src.next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			s.err = err
+			return
+		}
+		if !s.from.IsZero() && e.Timestamp.Before(s.from) {
+			continue
+		}
+		if !s.to.IsZero() && e.Timestamp.After(s.to) {
+			continue
+		}
+		heap.Push(&s.heads, sourceHead{entry: e, source: i})
+		return
+	}
+}
+
+// Next advances to the next entry, returning false when there are no
+// more entries or an error has occurred.
+func (s *LogSet) Next() bool {
+	if s.err != nil || s.heads.Len() == 0 {
+		s.entry = nil
+		return false
+	}
+	head := heap.Pop(&s.heads).(sourceHead)
+	s.entry = head.entry
+	s.push(head.source, s.sources[head.source])
+	return true
+}
+
+// Entry returns the current entry.
+func (s *LogSet) Entry() *log.Entry {
+	return s.entry
+}
+
+// Err returns the error, if any, that stopped Next.
+func (s *LogSet) Err() error {
+	return s.err
+}
+
+// Close closes all of the files read by the LogSet.
+func (s *LogSet) Close() error {
+	var err error
+	for _, src := range s.sources {
+		if cerr := src.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// sourceHead is the next pending entry of a source.
+type sourceHead struct {
+	entry  *log.Entry
+	source int
+}
+
+// sourceHeap orders the pending entries of a LogSet's sources by
+// timestamp, and then by the order of the sources, oldest first.
+type sourceHeap []sourceHead
+
+func (h sourceHeap) Len() int { return len(h) }
+
+func (h sourceHeap) Less(i, j int) bool {
+	if h[i].entry.Timestamp.Equal(h[j].entry.Timestamp) {
+		return h[i].source < h[j].source
+	}
+	return h[i].entry.Timestamp.Before(h[j].entry.Timestamp)
+}
+
+func (h sourceHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *sourceHeap) Push(x interface{}) { *h = append(*h, x.(sourceHead)) }
+
+func (h *sourceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,141 @@
+package apexorc
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+func testReadLogSet(t *testing.T, set *LogSet) []string {
+	var msgs []string
+	var last time.Time
+	for set.Next() {
+		e := set.Entry()
+		if e.Timestamp.Before(last) {
+			t.Errorf("Entry %q is out of order", e.Message)
+		}
+		last = e.Timestamp
+		// Skip anything apexorc logged about its own failures.
+		if _, ok := e.Fields["function"]; ok {
+			continue
+		}
+		msgs = append(msgs, e.Message)
+	}
+	if err := set.Err(); err != nil {
+		t.Fatalf("Error reading log set: %s", err)
+	}
+	return msgs
+}
+
+// A LogSet reads archives, quarantined journals and the live journal
+// as one stream.
+func TestLogSet(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-logset")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	failing := false
+	archiveF := func(oldPath string) error {
+		if failing {
+			return errors.New("Not today")
+		}
+		return NumericArchiveF(oldPath)
+	}
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, archiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	rotator.SetConversionRetries(1, 0)
+	log.SetHandler(rotator)
+
+	log.Info("Archived 1")
+	log.Info("Archived 2")
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	start := time.Now()
+	log.Info("Quarantined")
+	failing = true
+	if err = rotator.Rotate(); err == nil {
+		t.Fatal("Expected an error rotating")
+	}
+	failing = false
+	log.Info("Live")
+
+	set, err := rotator.OpenLogSet(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Error opening log set: %s", err)
+	}
+	// Rotation after opening must not change what we read.
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	log.Info("Too late")
+	msgs := testReadLogSet(t, set)
+	set.Close()
+	expected := []string{"Archived 1", "Archived 2", "Quarantined", "Live"}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+
+	set, err = OpenLogSet(path, start, time.Time{})
+	if err != nil {
+		t.Fatalf("Error opening log set: %s", err)
+	}
+	msgs = testReadLogSet(t, set)
+	set.Close()
+	expected = []string{"Quarantined", "Live", "Too late"}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+}
+
+// A LogSet opened while a journal's files are being archived reads
+// each entry once, whether or not the journal is converted in chunks.
+func TestLogSetDuringArchiving(t *testing.T) {
+	for _, chunkRows := range []int{0, 2} {
+		fsys := NewMemFS()
+		path := "/testlog.orc"
+		var rotator *RotatingHandler
+		var seen [][]string
+		archiveF := func(oldPath string) error {
+			if err := numericArchive(fsys, oldPath); err != nil {
+				return err
+			}
+			set, err := rotator.OpenLogSet(time.Time{}, time.Time{})
+			if err != nil {
+				return err
+			}
+			defer set.Close()
+			seen = append(seen, testReadLogSet(t, set))
+			return nil
+		}
+		var err error
+		rotator, err = NewRotatingHandler(path, archiveF, WithFS(fsys), ChunkedConversion(chunkRows))
+		if err != nil {
+			t.Fatalf("Error creating rotating handler: %s", err)
+		}
+		expected := testChunkMessages(5)
+		testLogChunkEntries(t, rotator, expected)
+		if err = rotator.Rotate(); err != nil {
+			t.Fatalf("Error rotating: %s", err)
+		}
+		if len(seen) == 0 {
+			t.Fatal("Expected the ArchiveFunc to be called")
+		}
+		for _, msgs := range seen {
+			if !reflect.DeepEqual(msgs, expected) {
+				t.Errorf("With chunks of %d, expected %q while archiving, got %q", chunkRows, expected, msgs)
+			}
+		}
+	}
+}
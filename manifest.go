@@ -0,0 +1,148 @@
+package apexorc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// manifestSuffix is appended to the path of an ORC file to give the
+// path of its manifest.
+const manifestSuffix = ".manifest.json"
+
+// Manifest describes an ORC file produced by a RotatingHandler.  It is
+// written as JSON alongside the ORC file, at the path given by
+// ManifestPath, so that the file can be catalogued and checked
+// without having to be opened.  SourceJournal names the journal the
+// file was converted from, RotatedAt is when that journal was
+// rotated, and StagingID identifies the directory it was staged in,
+// which tells apart the files converted from one rotation; they are
+// empty, and RotatedAt zero, for files written directly by a
+// RotatingHandler created with the DirectORC option.
+// KeyID names the key the file was encrypted with, and is empty if it
+// isn't encrypted; Size and SHA256 describe the file as stored.
+type Manifest struct {
+	SchemaVersion int              `json:"schema_version"`
+	Rows          int64            `json:"rows"`
+	Levels        map[string]int64 `json:"levels"`
+	MinTimestamp  time.Time        `json:"min_timestamp"`
+	MaxTimestamp  time.Time        `json:"max_timestamp"`
+	Size          int64            `json:"size"`
+	SHA256        string           `json:"sha256"`
+	Hostname      string           `json:"hostname"`
+	SourceJournal string           `json:"source_journal,omitempty"`
+	RotatedAt     time.Time        `json:"rotated_at"`
+	StagingID     string           `json:"staging_id,omitempty"`
+	KeyID         string           `json:"key_id,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+}
+
+// ManifestPath returns the path of the manifest for the ORC file at
+// orcPath.  An ArchiveFunc other than NumericArchiveF should move the
+// manifest along with the ORC file.
+func ManifestPath(orcPath string) string {
+	return orcPath + manifestSuffix
+}
+
+// ReadManifest reads the manifest for the ORC file at orcPath.
+func ReadManifest(orcPath string) (*Manifest, error) {
+	return readManifest(OSFS{}, orcPath)
+}
+
+// ReadManifestFS is like ReadManifest, but reads the manifest from
+// fsys.
+func ReadManifestFS(fsys FS, orcPath string) (*Manifest, error) {
+	return readManifest(fsys, orcPath)
+}
+
+func readManifest(fsys FS, orcPath string) (*Manifest, error) {
+	b, err := readFile(fsys, ManifestPath(orcPath))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// entryStats accumulates the details of the entries written to an ORC
+// file that are recorded in its Manifest.
+type entryStats struct {
+	rows   int64
+	levels map[string]int64
+	min    time.Time
+	max    time.Time
+}
+
+func (s *entryStats) add(e *log.Entry) {
+	if s.levels == nil {
+		s.levels = make(map[string]int64)
+	}
+	s.rows++
+	s.levels[e.Level.String()]++
+	if s.min.IsZero() || e.Timestamp.Before(s.min) {
+		s.min = e.Timestamp
+	}
+	_, last := RepeatOf(e)
+	if last.After(s.max) {
+		s.max = last
+	}
+}
+
+// writeManifest writes the manifest for the ORC file at orcPath,
+// which must be complete.  Like the ORC file itself, the manifest is
+// written to a temporary file first, so it is never seen partially
+// written.
+func writeManifest(fsys FS, orcPath string, stats entryStats, info rotationInfo) error {
+	f, err := fsys.Open(orcPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	keyID, _ := envelopeKeyID(io.NewSectionReader(f, 0, int64(maxEnvelopeHeader)))
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+
+	m := Manifest{
+		SchemaVersion: entrySchemaVersion,
+		Rows:          stats.rows,
+		Levels:        stats.levels,
+		MinTimestamp:  stats.min,
+		MaxTimestamp:  stats.max,
+		Size:          size,
+		SHA256:        hex.EncodeToString(hash.Sum(nil)),
+		Hostname:      hostname,
+		SourceJournal: info.Journal,
+		RotatedAt:     info.RotatedAt,
+		StagingID:     info.StagingID,
+		KeyID:         keyID,
+		CreatedAt:     time.Now(),
+	}
+	if m.Levels == nil {
+		m.Levels = map[string]int64{}
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	manifestPath := ManifestPath(orcPath)
+	tmpPath := makeTempPathFromPath(manifestPath)
+	err = writeFile(fsys, tmpPath, b, 0644)
+	if err != nil {
+		return err
+	}
+	return fsys.Rename(tmpPath, manifestPath)
+}
@@ -0,0 +1,110 @@
+package apexorc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func testCheckManifest(t *testing.T, orcPath string, rows int64, levels map[string]int64, fromJournal bool) {
+	m, err := ReadManifest(orcPath)
+	if err != nil {
+		t.Fatalf("Error reading manifest for %q: %s", orcPath, err)
+	}
+	if m.Rows != rows {
+		t.Errorf("Expected %d rows, got %d", rows, m.Rows)
+	}
+	for level, count := range levels {
+		if m.Levels[level] != count {
+			t.Errorf("Expected %d %s entries, got %d", count, level, m.Levels[level])
+		}
+	}
+	if m.MinTimestamp.IsZero() || m.MaxTimestamp.Before(m.MinTimestamp) {
+		t.Errorf("Unexpected timestamp range %v - %v", m.MinTimestamp, m.MaxTimestamp)
+	}
+	if m.SchemaVersion != entrySchemaVersion {
+		t.Errorf("Expected schema version %d, got %d", entrySchemaVersion, m.SchemaVersion)
+	}
+	if fromJournal {
+		if m.SourceJournal != "testlog.jrnl" || m.RotatedAt.IsZero() || !strings.HasPrefix(m.StagingID, journalDirPrefix) {
+			t.Errorf("Unexpected source %q, rotated at %v, staged in %q", m.SourceJournal, m.RotatedAt, m.StagingID)
+		}
+	} else if m.SourceJournal != "" || !m.RotatedAt.IsZero() || m.StagingID != "" {
+		t.Errorf("Expected no source journal, got %q, rotated at %v, staged in %q", m.SourceJournal, m.RotatedAt, m.StagingID)
+	}
+
+	content, err := ioutil.ReadFile(orcPath)
+	if err != nil {
+		t.Fatalf("Error reading ORC file: %s", err)
+	}
+	if m.Size != int64(len(content)) {
+		t.Errorf("Expected size %d, got %d", len(content), m.Size)
+	}
+	sum := sha256.Sum256(content)
+	if m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Manifest SHA-256 doesn't match the ORC file")
+	}
+}
+
+// Every archived ORC file gets a manifest, which moves with it as it
+// is pushed back by later archives.
+func TestManifest(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-manifest")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, NumericArchiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	log.SetHandler(rotator)
+	log.Info("One")
+	log.Info("Two")
+	log.Warn("Three")
+	err = rotator.Rotate()
+	if err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	log.Error("Four")
+	err = rotator.Rotate()
+	if err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+
+	testCheckManifest(t, path+".2", 3, map[string]int64{"info": 2, "warn": 1}, true)
+	testCheckManifest(t, path+".1", 1, map[string]int64{"error": 1}, true)
+	if _, err := os.Stat(ManifestPath(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected no manifest left at %q", ManifestPath(path))
+	}
+}
+
+func TestManifestDirectORC(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-manifest-direct")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, NumericArchiveF, DirectORC())
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	log.SetHandler(rotator)
+	log.Info("One")
+	log.Error("Two")
+	err = rotator.Rotate()
+	if err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	testCheckManifest(t, path+".1", 2, map[string]int64{"info": 1, "error": 1}, false)
+}
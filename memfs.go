@@ -0,0 +1,383 @@
+package apexorc
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFS is an FS that keeps its files in memory, for tests.  Open
+// files behave as they would on a POSIX filesystem: they carry on
+// working after being renamed or removed.
+type MemFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode
+	locks map[string]bool
+}
+
+// memNode is a file or directory in a MemFS.
+type memNode struct {
+	mu      sync.Mutex
+	data    []byte
+	dir     bool
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// NewMemFS returns an empty MemFS, holding only the root directory
+// and the current directory.
+func NewMemFS() *MemFS {
+	now := time.Now()
+	return &MemFS{nodes: map[string]*memNode{
+		string(filepath.Separator): {dir: true, mode: os.ModeDir | 0755, modTime: now},
+		".":                        {dir: true, mode: os.ModeDir | 0755, modTime: now},
+	}}
+}
+
+func memPathError(op, name string, err error) error {
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// parent checks that the directory to hold name exists.  The caller
+// must hold m.mu.
+func (m *MemFS) parent(op, name string) error {
+	dir, ok := m.nodes[filepath.Dir(name)]
+	if !ok {
+		return memPathError(op, name, os.ErrNotExist)
+	}
+	if !dir.dir {
+		return memPathError(op, name, syscall.ENOTDIR)
+	}
+	return nil
+}
+
+// Create creates or truncates the named file.
+func (m *MemFS) Create(name string) (File, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nodes[name]
+	if ok && n.dir {
+		return nil, memPathError("open", name, syscall.EISDIR)
+	}
+	if !ok {
+		if err := m.parent("open", name); err != nil {
+			return nil, err
+		}
+		n = &memNode{mode: 0666}
+		m.nodes[name] = n
+	}
+	n.mu.Lock()
+	n.data = nil
+	n.modTime = time.Now()
+	n.mu.Unlock()
+	return &memFile{name: name, node: n, writable: true}, nil
+}
+
+// Open opens the named file for reading.
+func (m *MemFS) Open(name string) (File, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, memPathError("open", name, os.ErrNotExist)
+	}
+	return &memFile{name: name, node: n}, nil
+}
+
+// OpenAppend opens the named file for reading and appending.
+func (m *MemFS) OpenAppend(name string) (File, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nodes[name]
+	if ok && n.dir {
+		return nil, memPathError("open", name, syscall.EISDIR)
+	}
+	if !ok {
+		if err := m.parent("open", name); err != nil {
+			return nil, err
+		}
+		n = &memNode{mode: 0666, modTime: time.Now()}
+		m.nodes[name] = n
+	}
+	return &memFile{name: name, node: n, writable: true, append: true}, nil
+}
+
+// Rename moves a file or directory.
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nodes[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if err := m.parent("rename", newpath); err != nil {
+		return err
+	}
+	if existing, ok := m.nodes[newpath]; ok && existing.dir != n.dir {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrExist}
+	}
+	delete(m.nodes, oldpath)
+	m.nodes[newpath] = n
+	if n.dir {
+		prefix := oldpath + string(filepath.Separator)
+		for name, child := range m.nodes {
+			if strings.HasPrefix(name, prefix) {
+				delete(m.nodes, name)
+				m.nodes[newpath+string(filepath.Separator)+name[len(prefix):]] = child
+			}
+		}
+	}
+	return nil
+}
+
+// Remove removes a file or an empty directory.
+func (m *MemFS) Remove(name string) error {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nodes[name]
+	if !ok {
+		return memPathError("remove", name, os.ErrNotExist)
+	}
+	if n.dir && len(m.children(name)) > 0 {
+		return memPathError("remove", name, syscall.ENOTEMPTY)
+	}
+	delete(m.nodes, name)
+	return nil
+}
+
+// RemoveAll removes a file or directory and everything in it.
+func (m *MemFS) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prefix := path + string(filepath.Separator)
+	for name := range m.nodes {
+		if name == path || strings.HasPrefix(name, prefix) {
+			delete(m.nodes, name)
+		}
+	}
+	return nil
+}
+
+// Stat describes the named file or directory.
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, memPathError("stat", name, os.ErrNotExist)
+	}
+	return n.info(name), nil
+}
+
+// ReadDir lists a directory, sorted by name.
+func (m *MemFS) ReadDir(name string) ([]os.FileInfo, error) {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, memPathError("open", name, os.ErrNotExist)
+	}
+	if !n.dir {
+		return nil, memPathError("readdirent", name, syscall.ENOTDIR)
+	}
+	names := m.children(name)
+	sort.Strings(names)
+	infos := make([]os.FileInfo, len(names))
+	for i, child := range names {
+		infos[i] = m.nodes[child].info(child)
+	}
+	return infos, nil
+}
+
+// children returns the paths of the direct children of dir.  The
+// caller must hold m.mu.
+func (m *MemFS) children(dir string) []string {
+	var names []string
+	for name := range m.nodes {
+		if name != dir && filepath.Dir(name) == dir {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Mkdir creates a directory.
+func (m *MemFS) Mkdir(name string, perm os.FileMode) error {
+	name = filepath.Clean(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.nodes[name]; ok {
+		return memPathError("mkdir", name, os.ErrExist)
+	}
+	if err := m.parent("mkdir", name); err != nil {
+		return err
+	}
+	m.nodes[name] = &memNode{dir: true, mode: os.ModeDir | perm, modTime: time.Now()}
+	return nil
+}
+
+// MkdirAll creates a directory and any parents it needs.
+func (m *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var missing []string
+	for p := path; ; p = filepath.Dir(p) {
+		n, ok := m.nodes[p]
+		if ok {
+			if !n.dir {
+				return memPathError("mkdir", p, syscall.ENOTDIR)
+			}
+			break
+		}
+		missing = append(missing, p)
+		if p == filepath.Dir(p) {
+			break
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		m.nodes[missing[i]] = &memNode{dir: true, mode: os.ModeDir | perm, modTime: time.Now()}
+	}
+	return nil
+}
+
+func (n *memNode) info(name string) *memFileInfo {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return &memFileInfo{name: filepath.Base(name), size: int64(len(n.data)), mode: n.mode, modTime: n.modTime, node: n}
+}
+
+// memFileInfo describes a memNode.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	node    *memNode
+}
+
+func (i *memFileInfo) Name() string       { return i.name }
+func (i *memFileInfo) Size() int64        { return i.size }
+func (i *memFileInfo) Mode() os.FileMode  { return i.mode }
+func (i *memFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memFileInfo) IsDir() bool        { return i.node.dir }
+func (i *memFileInfo) Sys() interface{}   { return nil }
+
+// memFile is an open memNode.
+type memFile struct {
+	name     string
+	node     *memNode
+	offset   int64
+	writable bool
+	append   bool
+	closed   bool
+}
+
+func (f *memFile) check(op string) error {
+	if f.closed {
+		return memPathError(op, f.name, os.ErrClosed)
+	}
+	if f.node.dir {
+		return memPathError(op, f.name, syscall.EISDIR)
+	}
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if err := f.check("write"); err != nil {
+		return 0, err
+	}
+	if !f.writable {
+		return 0, memPathError("write", f.name, errors.New("file not open for writing"))
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	if f.append {
+		f.offset = int64(len(f.node.data))
+	}
+	if gap := f.offset - int64(len(f.node.data)); gap > 0 {
+		f.node.data = append(f.node.data, make([]byte, gap)...)
+	}
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data[:f.offset], p...)
+	} else {
+		copy(f.node.data[f.offset:], p)
+	}
+	f.offset = end
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	if f.closed {
+		return memPathError("close", f.name, os.ErrClosed)
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, memPathError("stat", f.name, os.ErrClosed)
+	}
+	return f.node.info(f.name), nil
+}
+
+func (f *memFile) Sync() error {
+	return f.check("sync")
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if err := f.check("truncate"); err != nil {
+		return err
+	}
+	f.node.mu.Lock()
+	defer f.node.mu.Unlock()
+	if size < int64(len(f.node.data)) {
+		f.node.data = f.node.data[:size]
+	} else {
+		f.node.data = append(f.node.data, make([]byte, size-int64(len(f.node.data)))...)
+	}
+	return nil
+}
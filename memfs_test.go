@@ -0,0 +1,241 @@
+package apexorc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// MemFS reports missing and existing files the way the os package
+// does.
+func TestMemFSErrors(t *testing.T) {
+	fsys := NewMemFS()
+	if _, err := fsys.Open("/missing"); !os.IsNotExist(err) {
+		t.Errorf("Expected a not-exist error opening a missing file, got %v", err)
+	}
+	if _, err := fsys.Create("/missing/file"); !os.IsNotExist(err) {
+		t.Errorf("Expected a not-exist error creating a file in a missing directory, got %v", err)
+	}
+	if err := fsys.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("Error making directory: %s", err)
+	}
+	if err := fsys.Mkdir("/dir", 0755); !os.IsExist(err) {
+		t.Errorf("Expected an exist error making a directory twice, got %v", err)
+	}
+	f, err := fsys.Create("/dir/file")
+	if err != nil {
+		t.Fatalf("Error creating file: %s", err)
+	}
+	f.Close()
+	if _, err := f.Write([]byte("late")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Expected os.ErrClosed writing to a closed file, got %v", err)
+	}
+	if err := fsys.Remove("/dir"); err == nil {
+		t.Error("Expected an error removing a non-empty directory")
+	}
+}
+
+// Files in a MemFS can be appended to, and renaming a directory
+// moves everything in it.
+func TestMemFSAppendAndRename(t *testing.T) {
+	fsys := NewMemFS()
+	if err := fsys.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatalf("Error making directories: %s", err)
+	}
+	for _, s := range []string{"one\n", "two\n"} {
+		f, err := fsys.OpenAppend("/a/b/file")
+		if err != nil {
+			t.Fatalf("Error opening file: %s", err)
+		}
+		if _, err = f.Write([]byte(s)); err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+		f.Close()
+	}
+	if err := fsys.Rename("/a", "/c"); err != nil {
+		t.Fatalf("Error renaming: %s", err)
+	}
+	b, err := readFile(fsys, "/c/b/file")
+	if err != nil {
+		t.Fatalf("Error reading renamed file: %s", err)
+	}
+	if string(b) != "one\ntwo\n" {
+		t.Errorf("Expected %q, got %q", "one\ntwo\n", b)
+	}
+	if _, err := fsys.Stat("/a/b/file"); !os.IsNotExist(err) {
+		t.Errorf("Expected the old path to be gone, got %v", err)
+	}
+	infos, err := fsys.ReadDir("/c/b")
+	if err != nil {
+		t.Fatalf("Error reading directory: %s", err)
+	}
+	if len(infos) != 1 || infos[0].Name() != "file" || infos[0].Size() != 8 {
+		t.Errorf("Unexpected directory listing: %v", infos)
+	}
+}
+
+// A RotatingHandler on a MemFS journals, converts, archives and reads
+// back its logs without touching the disk.
+func TestRotatingHandlerMemFS(t *testing.T) {
+	fsys := NewMemFS()
+	if err := fsys.MkdirAll("/logs", 0755); err != nil {
+		t.Fatalf("Error making directory: %s", err)
+	}
+	path := "/logs/testlog.orc"
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	log.SetHandler(rotator)
+
+	log.Info("First")
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	log.Info("Second")
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	log.Info("Live")
+
+	for _, p := range []string{path + ".1", path + ".2", ManifestPath(path + ".1")} {
+		if _, err := fsys.Stat(p); err != nil {
+			t.Errorf("Expected %s to exist: %s", p, err)
+		}
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written to disk, got %v", err)
+	}
+
+	m, err := readManifest(fsys, path+".1")
+	if err != nil {
+		t.Fatalf("Error reading manifest: %s", err)
+	}
+	if m.Rows != 1 {
+		t.Errorf("Expected 1 row in the manifest, got %d", m.Rows)
+	}
+
+	set, err := rotator.OpenLogSet(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Error opening log set: %s", err)
+	}
+	defer set.Close()
+	msgs := testReadLogSet(t, set)
+	expected := []string{"First", "Second", "Live"}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %v, got %v", expected, msgs)
+	}
+}
+
+// OSFS files are the os package's own.
+func TestOSFS(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-fs")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var fsys FS = OSFS{}
+	dir, err := tempDir(fsys, tmpdir, "x")
+	if err != nil {
+		t.Fatalf("Error making temporary directory: %s", err)
+	}
+	name := dir + "/file"
+	if err = writeFile(fsys, name, []byte("data"), 0600); err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("Error from os.Stat: %s", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+	again, err := fsys.Stat(name)
+	if err != nil {
+		t.Fatalf("Error from Stat: %s", err)
+	}
+	if !sameFile(info, again) {
+		t.Error("Expected the same file")
+	}
+}
+
+// The package's functions that take an FS work with one other than
+// OSFS.
+func TestPackageFunctionsFS(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	testLogChunkEntries(t, rotator, []string{"Archived"})
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	testLogChunkEntries(t, rotator, []string{"Journalled"})
+
+	archives, err := ListArchivesFS(fsys, path)
+	if err != nil || len(archives) != 1 || archives[0].Path != path+".1" {
+		t.Fatalf("Expected %s.1 to be listed, got %v, %v", path, archives, err)
+	}
+	m, err := ReadManifestFS(fsys, path+".1")
+	if err != nil || m.Rows != 1 {
+		t.Errorf("Expected a manifest of 1 row, got %v, %v", m, err)
+	}
+
+	set, err := OpenLogSetFS(fsys, path, nil, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Error opening log set: %s", err)
+	}
+	expected := []string{"Archived", "Journalled"}
+	if msgs := testReadLogSet(t, set); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q from the log set, got %q", expected, msgs)
+	}
+	set.Close()
+
+	var journal bytes.Buffer
+	if err = DecryptFileFS(fsys, &journal, rotator.journalPath, nil); err != nil {
+		t.Errorf("Error copying the journal: %s", err)
+	} else if !strings.Contains(journal.String(), "Journalled") {
+		t.Errorf("Expected the journal to be copied, got %q", journal.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var followed []string
+	err = FollowFS(ctx, fsys, path, nil, 2, log.HandlerFunc(func(e *log.Entry) error {
+		followed = append(followed, e.Message)
+		if len(followed) == 2 {
+			cancel()
+		}
+		return nil
+	}))
+	if err != context.Canceled || !reflect.DeepEqual(followed, expected) {
+		t.Errorf("Expected to follow %q, got %q and %v", expected, followed, err)
+	}
+
+	rows, err := TranscodeORCToParquetFS(fsys, path+".1", "/testlog.parquet", nil)
+	if err != nil || rows != 1 {
+		t.Errorf("Expected 1 row transcoded, got %d, %v", rows, err)
+	}
+	if _, err := fsys.Stat("/testlog.parquet"); err != nil {
+		t.Errorf("Expected a Parquet file: %s", err)
+	}
+
+	rows, err = ConvertJSONLinesFS(fsys, "/converted.orc", DefaultKeyMapping,
+		strings.NewReader(`{"timestamp":"2017-06-01T12:00:00Z","level":"info","message":"Converted"}`+"\n"))
+	if err != nil || rows != 1 {
+		t.Fatalf("Expected 1 row converted, got %d, %v", rows, err)
+	}
+	if msgs := testArchiveMessages(t, fsys, "/converted.orc"); !reflect.DeepEqual(msgs, []string{"Converted"}) {
+		t.Errorf("Expected the converted entry, got %q", msgs)
+	}
+}
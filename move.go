@@ -0,0 +1,57 @@
+package apexorc
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// moveFile renames oldPath to newPath.  Should they be on different
+// devices, where os.Rename fails with EXDEV, it falls back to copying
+// the file and removing the original.
+func moveFile(fsys FS, oldPath, newPath string) error {
+	err := fsys.Rename(oldPath, newPath)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	return copyAndRemove(fsys, oldPath, newPath)
+}
+
+// copyAndRemove copies oldPath to newPath, syncs the copy and only
+// then removes oldPath, so a failure part way through never loses the
+// original.
+func copyAndRemove(fsys FS, oldPath, newPath string) error {
+	src, err := fsys.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := fsys.Create(newPath)
+	if err != nil {
+		return err
+	}
+	// Keep the original's permissions, where the FS has them.
+	if c, ok := dst.(interface{ Chmod(os.FileMode) error }); ok {
+		err = c.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		_, err = io.Copy(dst, src)
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fsys.Remove(newPath)
+		return err
+	}
+	return fsys.Remove(oldPath)
+}
@@ -0,0 +1,53 @@
+package apexorc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-move")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// We can't conjure up a second device in a test, so exercise
+	// the cross-device fallback directly as well as the rename.
+	for name, move := range map[string]func(FS, string, string) error{
+		"moveFile":      moveFile,
+		"copyAndRemove": copyAndRemove,
+	} {
+		oldPath := filepath.Join(tmpdir, name+".old")
+		newPath := filepath.Join(tmpdir, name+".new")
+		original := []byte("journal content")
+		err = ioutil.WriteFile(oldPath, original, 0600)
+		if err != nil {
+			t.Fatalf("Error creating tempfile: %s", err.Error())
+		}
+
+		err = move(OSFS{}, oldPath, newPath)
+		if err != nil {
+			t.Fatalf("[%s] Error moving file: %s", name, err)
+		}
+		if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+			t.Errorf("[%s] Expected %q to be removed", name, oldPath)
+		}
+		content, err := ioutil.ReadFile(newPath)
+		if err != nil {
+			t.Fatalf("[%s] Error reading moved file: %s", name, err)
+		}
+		if string(content) != string(original) {
+			t.Errorf("[%s] Expected %q, got %q", name, original, content)
+		}
+		info, err := os.Stat(newPath)
+		if err != nil {
+			t.Fatalf("[%s] Error from os.Stat: %s", name, err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("[%s] Expected mode 0600, got %v", name, info.Mode().Perm())
+		}
+	}
+}
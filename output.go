@@ -0,0 +1,217 @@
+package apexorc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// OutputFormat is a file format that a RotatingHandler can archive
+// log entries in.
+type OutputFormat int
+
+const (
+	// FormatORC writes ORC files using a Handler.
+	FormatORC OutputFormat = iota
+	// FormatParquet writes Parquet files using a ParquetHandler,
+	// at the path given by ParquetPath.
+	FormatParquet
+)
+
+// OutputFormats is an Option that sets the formats that a
+// RotatingHandler archives log entries in.  By default only ORC files
+// are written.  Each file is archived, with its own Manifest, by the
+// ArchiveFunc.  Note that ListArchives, LogSet, Follow and the
+// QueryHandler only read ORC files, so without FormatORC they will
+// only find entries that are yet to be archived.  NewRotatingHandler
+// fails if no formats are given, or one is unknown or repeated.
+func OutputFormats(formats ...OutputFormat) Option {
+	return func(h *RotatingHandler) {
+		h.formats = formats
+	}
+}
+
+// checkFormats returns an error unless formats is a list of known
+// formats, without repeats, that something can be written in.
+func checkFormats(formats []OutputFormat) error {
+	if len(formats) == 0 {
+		return fmt.Errorf("apexorc: no output formats")
+	}
+	seen := make(map[OutputFormat]bool)
+	for _, format := range formats {
+		if format != FormatORC && format != FormatParquet {
+			return fmt.Errorf("apexorc: unknown output format %d", format)
+		}
+		if seen[format] {
+			return fmt.Errorf("apexorc: output format %d given twice", format)
+		}
+		seen[format] = true
+	}
+	return nil
+}
+
+// ParquetPath returns the path of the Parquet file written alongside
+// the ORC file at orcPath: orcPath with any .orc extension replaced
+// by .parquet.
+func ParquetPath(orcPath string) string {
+	if filepath.Ext(orcPath) == ".orc" {
+		orcPath = strings.TrimSuffix(orcPath, ".orc")
+	}
+	return orcPath + ".parquet"
+}
+
+// fileHandler is a CloserHandler that writes a file, only publishing
+// it at filePath once it is closed.
+type fileHandler interface {
+	CloserHandler
+	filePath() string
+	entryStats() entryStats
+	discard()
+}
+
+// newFileHandlers returns a fileHandler for each of formats, for the
+// log at path on fsys, encrypting their files with keys unless it is
+// nil.
+func newFileHandlers(fsys FS, path string, formats []OutputFormat, keys KeyProvider) []fileHandler {
+	handlers := make([]fileHandler, 0, len(formats))
+	for _, format := range formats {
+		switch format {
+		case FormatORC:
+			h := NewHandler(path)
+			h.fs = fsys
+			h.keys = keys
+			handlers = append(handlers, h)
+		case FormatParquet:
+			h := NewParquetHandler(ParquetPath(path))
+			h.fs = fsys
+			h.keys = keys
+			handlers = append(handlers, h)
+		}
+	}
+	return handlers
+}
+
+// outputFile is the temporary file that a Handler or ParquetHandler
+// writes to before publishing it.
+type outputFile struct {
+	fs   FS
+	file File
+	w    io.Writer
+	env  *envelopeWriter // env encrypts the file, if it is encrypted.
+	buf  *bufio.Writer   // buf batches writes into frames when encrypting.
+}
+
+// createOutputFile creates the temporary file for path on fsys,
+// encrypting it with keys unless it is nil.
+func createOutputFile(fsys FS, path string, keys KeyProvider) (*outputFile, error) {
+	f, err := fsys.Create(makeTempPathFromPath(path))
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return &outputFile{fs: fsys, file: f, w: f}, nil
+	}
+	ew, err := newEnvelopeWriter(f, keys, true)
+	if err != nil {
+		f.Close()
+		fsys.Remove(f.Name())
+		return nil, err
+	}
+	buf := bufio.NewWriterSize(ew, maxFramePlain)
+	return &outputFile{fs: fsys, file: f, w: buf, env: ew, buf: buf}, nil
+}
+
+// writer returns the io.Writer that the file's contents should be
+// written to.  It hides any Close method, so the ORC and Parquet
+// writers can't close the file before publish has synced it.
+func (o *outputFile) writer() io.Writer {
+	return struct{ io.Writer }{o.w}
+}
+
+// publish flushes, syncs and closes the file, then renames it to
+// path.  An encrypted file is finished with its final frame first.
+func (o *outputFile) publish(path string) error {
+	var err error
+	if o.buf != nil {
+		err = o.buf.Flush()
+	}
+	if err == nil && o.env != nil {
+		err = o.env.finish()
+	}
+	if err == nil {
+		err = o.file.Sync()
+	}
+	if err != nil {
+		o.file.Close()
+		return err
+	}
+	err = o.file.Close()
+	if err != nil {
+		return err
+	}
+	return o.fs.Rename(o.file.Name(), path)
+}
+
+// fanOutHandler passes each log entry to several fileHandlers.
+type fanOutHandler []fileHandler
+
+func (f fanOutHandler) HandleLog(e *log.Entry) error {
+	var err error
+	for _, h := range f {
+		if herr := h.HandleLog(e); err == nil {
+			err = herr
+		}
+	}
+	return err
+}
+
+// Close closes every handler, returning the first error.
+func (f fanOutHandler) Close() error {
+	var err error
+	for _, h := range f {
+		if cerr := h.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// discard abandons every handler's file.
+func (f fanOutHandler) discard() {
+	for _, h := range f {
+		h.discard()
+	}
+}
+
+// setAside closes the file, which couldn't be published to path, and
+// renames it out of the way, so it isn't truncated when the next file
+// for path is created.  The returned error describes failure, which
+// was err, and says where the file went.
+func (o *outputFile) setAside(path string, failure error) error {
+	o.file.Close()
+	asidePath := makeFailedPathFromPath(path, time.Now())
+	err := o.fs.Rename(o.file.Name(), asidePath)
+	if err != nil {
+		return fmt.Errorf("%w (and it couldn't be set aside: %s)", failure, err)
+	}
+	return fmt.Errorf("%w (the file has been kept at %s)", failure, asidePath)
+}
+
+// makeFailedPathFromPath returns the path of the hidden file, in the
+// same directory as srcPath, that a file which couldn't be published
+// to srcPath at time t is kept at.
+func makeFailedPathFromPath(srcPath string, t time.Time) string {
+	dir, file := filepath.Split(srcPath)
+	return filepath.Join(dir, fmt.Sprintf(".%s.failed-%d", file, t.UnixNano()))
+}
+
+// discard closes and removes the file without publishing it.
+func (o *outputFile) discard() {
+	o.file.Close()
+	o.fs.Remove(o.file.Name())
+}
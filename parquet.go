@@ -0,0 +1,169 @@
+package apexorc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRow is the Parquet equivalent of entrySchema.
+type parquetRow struct {
+	Timestamp time.Time         `parquet:"timestamp,timestamp(nanosecond)"`
+	Level     string            `parquet:"level"`
+	Message   string            `parquet:"message"`
+	Fields    map[string]string `parquet:"fields"`
+
+	RepeatCount   int64     `parquet:"repeat_count"`
+	LastTimestamp time.Time `parquet:"last_timestamp,timestamp(nanosecond)"`
+
+	FieldTypes map[string]string `parquet:"field_types"`
+}
+
+// ParquetHandler complies with the github.com/apex/log.Handler
+// interface, like Handler, but writes a Parquet file with columns
+// equivalent to those of the ORC files written by Handler.
+type ParquetHandler struct {
+	mu     sync.Mutex
+	path   string
+	file   *outputFile
+	writer *parquet.GenericWriter[parquetRow]
+	stats  entryStats
+	keys   KeyProvider
+	fs     FS
+}
+
+// NewParquetHandler returns a ParquetHandler which can log to a
+// Parquet file at the provided path.  As with Handler, nothing
+// appears at that path until the handler is closed.
+func NewParquetHandler(path string) *ParquetHandler {
+	return &ParquetHandler{
+		path: path,
+		fs:   OSFS{},
+	}
+}
+
+// SetFS makes the ParquetHandler write its files to fsys, starting
+// with the next file it opens.
+func (h *ParquetHandler) SetFS(fsys FS) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fs = fsys
+}
+
+// SetKeyProvider makes the ParquetHandler encrypt the Parquet files
+// it writes with keys from kp, starting with the next file it opens.
+func (h *ParquetHandler) SetKeyProvider(kp KeyProvider) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.keys = kp
+}
+
+// HandleLog recieves new log.Entrys and writes them to a Parquet
+// file.
+func (h *ParquetHandler) HandleLog(e *log.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.writer == nil {
+		f, err := createOutputFile(h.fs, h.path, h.keys)
+		if err != nil {
+			return err
+		}
+		h.file = f
+		h.writer = parquet.NewGenericWriter[parquetRow](f.writer())
+		h.stats = entryStats{}
+	}
+	count, last := RepeatOf(e)
+	fields, types := formatFields(e.Fields, false)
+	_, err := h.writer.Write([]parquetRow{{
+		Timestamp:     e.Timestamp,
+		Level:         e.Level.String(),
+		Message:       e.Message,
+		Fields:        fields,
+		RepeatCount:   count,
+		LastTimestamp: last,
+		FieldTypes:    types,
+	}})
+	if err != nil {
+		return err
+	}
+	h.stats.add(e)
+	return nil
+}
+
+// Close finalises the underlying Parquet file.
+func (h *ParquetHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.writer == nil {
+		return nil
+	}
+	err := h.writer.Close()
+	h.writer = nil
+	f := h.file
+	h.file = nil
+	if err == nil {
+		err = f.publish(h.path)
+	}
+	if err != nil {
+		return f.setAside(h.path, err)
+	}
+	return nil
+}
+
+// discard abandons the current file, removing it rather than
+// publishing it.
+func (h *ParquetHandler) discard() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.writer != nil {
+		h.writer.Close()
+		h.writer = nil
+	}
+	if h.file != nil {
+		h.file.discard()
+		h.file = nil
+	}
+}
+
+func (h *ParquetHandler) filePath() string {
+	return h.path
+}
+
+func (h *ParquetHandler) entryStats() entryStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
+
+// TranscodeORCToParquet writes every entry of the ORC file at orcPath
+// to a new Parquet file at parquetPath, returning the number of
+// entries written.
+func TranscodeORCToParquet(orcPath, parquetPath string) (int64, error) {
+	return TranscodeORCToParquetFS(OSFS{}, orcPath, parquetPath, nil)
+}
+
+// TranscodeEncryptedORCToParquet is like TranscodeORCToParquet, but
+// reads an ORC file written with encryption enabled, decrypting it
+// with keys from kp.  The Parquet file is encrypted too, with kp's
+// current key, so the entries are never written out in the clear.
+func TranscodeEncryptedORCToParquet(orcPath, parquetPath string, kp KeyProvider) (int64, error) {
+	return TranscodeORCToParquetFS(OSFS{}, orcPath, parquetPath, kp)
+}
+
+// TranscodeORCToParquetFS is like TranscodeEncryptedORCToParquet, but
+// reads and writes the files on fsys.  kp may be nil if the ORC file
+// isn't encrypted.
+func TranscodeORCToParquetFS(fsys FS, orcPath, parquetPath string, kp KeyProvider) (int64, error) {
+	handler := NewParquetHandler(parquetPath)
+	handler.SetFS(fsys)
+	handler.SetKeyProvider(kp)
+	err := replayArchive(fsys, orcPath, kp, handler.HandleLog)
+	if cerr := handler.Close(); err == nil {
+		err = cerr
+	}
+	return handler.entryStats().rows, err
+}
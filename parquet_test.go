@@ -0,0 +1,242 @@
+package apexorc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParquetPath(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{"/home/baron/log.orc", "/home/baron/log.parquet"},
+		{"/home/baron/log", "/home/baron/log.parquet"},
+		{"log.txt", "log.txt.parquet"},
+	}
+	for cid, tcase := range cases {
+		parquetPath := ParquetPath(tcase.Input)
+		if parquetPath != tcase.Expected {
+			t.Errorf("[Case %d] Got %q, expected %q", cid, parquetPath, tcase.Expected)
+		}
+	}
+}
+
+func testReadParquetMessages(t *testing.T, parquetPath string) []parquetRow {
+	rows, err := parquet.ReadFile[parquetRow](parquetPath)
+	if err != nil {
+		t.Fatalf("Error reading Parquet file %q: %s", parquetPath, err)
+	}
+	return rows
+}
+
+// A RotatingHandler can archive Parquet files alongside ORC ones.
+func TestRotateToParquet(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-parquet")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	for _, opts := range [][]Option{
+		{OutputFormats(FormatORC, FormatParquet)},
+		{OutputFormats(FormatORC, FormatParquet), DirectORC()},
+	} {
+		rotator, err := NewRotatingHandler(path, NumericArchiveF, opts...)
+		if err != nil {
+			t.Fatalf("Error creating rotating handler: %s", err)
+		}
+		log.SetHandler(rotator)
+		log.WithField("shoes", "brogues").Info("Parquet floor")
+		err = rotator.Rotate()
+		if err != nil {
+			t.Fatalf("Error rotating: %s", err)
+		}
+
+		if _, err := os.Stat(path + ".1"); err != nil {
+			t.Errorf("Expected an ORC archive: %s", err)
+		}
+		parquetPath := ParquetPath(path) + ".1"
+		rows := testReadParquetMessages(t, parquetPath)
+		if len(rows) != 1 {
+			t.Fatalf("Expected 1 row, got %d", len(rows))
+		}
+		row := rows[0]
+		if row.Message != "Parquet floor" || row.Level != "info" || row.Fields["shoes"] != "brogues" {
+			t.Errorf("Unexpected row %+v", row)
+		}
+		m, err := ReadManifest(parquetPath)
+		if err != nil {
+			t.Fatalf("Error reading Parquet manifest: %s", err)
+		}
+		if m.Rows != 1 {
+			t.Errorf("Expected 1 row in the manifest, got %d", m.Rows)
+		}
+		// Let the next handler take the log.
+		rotator.Close(context.Background())
+	}
+	// The second handler's archive pushed back the first's.
+	if _, err := os.Stat(ParquetPath(path) + ".2"); err != nil {
+		t.Errorf("Expected an older Parquet archive: %s", err)
+	}
+}
+
+// Should archiving the Parquet file fail, the retry doesn't archive
+// the ORC file a second time.
+func TestRotateToParquetRetry(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	failing := true
+	archiveF := func(oldPath string) error {
+		if failing && oldPath == ParquetPath(path) {
+			failing = false
+			return errors.New("Not today")
+		}
+		return numericArchive(fsys, oldPath)
+	}
+	rotator, err := NewRotatingHandler(path, archiveF, WithFS(fsys), OutputFormats(FormatORC, FormatParquet))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	rotator.SetConversionRetries(2, 0)
+	if err = rotator.HandleLog(makeTestEntry("Parquet floor", nil, nil)); err != nil {
+		t.Fatalf("Error logging: %s", err)
+	}
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	for _, p := range []string{path + ".1", ParquetPath(path) + ".1"} {
+		if _, err := fsys.Stat(p); err != nil {
+			t.Errorf("Expected an archive: %s", err)
+		}
+	}
+	if _, err := fsys.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("Expected the ORC file to be archived once, got %v", err)
+	}
+	if staged, _ := listJournalDirs(fsys, rotator.stagingDir); len(staged) != 0 {
+		t.Errorf("Expected no staged journals, found %v", staged)
+	}
+}
+
+func TestTranscodeORCToParquet(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-transcode")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	orcPath := filepath.Join(tmpdir, "testlog.orc")
+	handler := NewHandler(orcPath)
+	for _, msg := range []string{"one", "two", "three"} {
+		err = handler.HandleLog(makeTestEntry(msg, nil, nil))
+		if err != nil {
+			t.Fatalf("Error logging: %s", err)
+		}
+	}
+	err = handler.Close()
+	if err != nil {
+		t.Fatalf("Error closing handler: %s", err)
+	}
+
+	parquetPath := ParquetPath(orcPath)
+	rows, err := TranscodeORCToParquet(orcPath, parquetPath)
+	if err != nil {
+		t.Fatalf("Error transcoding: %s", err)
+	}
+	if rows != 3 {
+		t.Errorf("Expected 3 rows transcoded, got %d", rows)
+	}
+	for i, row := range testReadParquetMessages(t, parquetPath) {
+		expected := []string{"one", "two", "three"}[i]
+		if row.Message != expected {
+			t.Errorf("Expected %q, got %q", expected, row.Message)
+		}
+	}
+}
+
+// An encrypted ORC file can be transcoded, given the keys, to a
+// Parquet file that is encrypted in turn.
+func TestTranscodeEncryptedORCToParquet(t *testing.T) {
+	fsys := NewMemFS()
+	keys := testKeyRing()
+	handler := NewHandler("/testlog.orc")
+	handler.SetFS(fsys)
+	handler.SetKeyProvider(keys)
+	expected := []string{"one", "two", "three"}
+	for _, msg := range expected {
+		if err := handler.HandleLog(makeTestEntry(msg, nil, nil)); err != nil {
+			t.Fatalf("Error logging: %s", err)
+		}
+	}
+	if err := handler.Close(); err != nil {
+		t.Fatalf("Error closing handler: %s", err)
+	}
+
+	if _, err := TranscodeORCToParquetFS(fsys, "/testlog.orc", "/plain.parquet", nil); err == nil {
+		t.Error("Expected an error transcoding without keys")
+	}
+	rows, err := TranscodeORCToParquetFS(fsys, "/testlog.orc", "/testlog.parquet", keys)
+	if err != nil || rows != 3 {
+		t.Fatalf("Expected 3 rows transcoded, got %d, %v", rows, err)
+	}
+	if b, _ := readFile(fsys, "/testlog.parquet"); bytes.HasPrefix(b, []byte("PAR1")) {
+		t.Error("Expected the Parquet file to be encrypted")
+	}
+	var plain bytes.Buffer
+	if err = DecryptFileFS(fsys, &plain, "/testlog.parquet", keys); err != nil {
+		t.Fatalf("Error decrypting: %s", err)
+	}
+	parquetRows, err := parquet.Read[parquetRow](bytes.NewReader(plain.Bytes()), int64(plain.Len()))
+	if err != nil {
+		t.Fatalf("Error reading Parquet file: %s", err)
+	}
+	var msgs []string
+	for _, row := range parquetRows {
+		msgs = append(msgs, row.Message)
+	}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+}
+
+// A RotatingHandler can't be created with nothing to write, and a
+// journal is never thrown away without being converted to something.
+func TestOutputFormatsChecked(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	for _, formats := range [][]OutputFormat{
+		{},
+		{OutputFormat(7)},
+		{FormatORC, FormatORC},
+	} {
+		_, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys), OutputFormats(formats...))
+		if err == nil {
+			t.Errorf("Expected an error for formats %v", formats)
+		}
+	}
+
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	rotator.SetConversionRetries(1, 0)
+	testLogChunkEntries(t, rotator, []string{"Kept"})
+	rotator.formats = nil
+	if err = rotator.Rotate(); err == nil {
+		t.Fatal("Expected an error rotating")
+	}
+	quarantined, _ := listJournalDirs(fsys, rotator.quarantineDir)
+	if len(quarantined) != 1 {
+		t.Errorf("Expected the journal to be quarantined, found %v", quarantined)
+	}
+}
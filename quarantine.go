@@ -0,0 +1,159 @@
+package apexorc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// quarantineManifestName is the name of the manifest written into
+// each quarantined journal's directory.
+const quarantineManifestName = "error.json"
+
+// quarantineManifest is written alongside a quarantined journal to
+// record why it was quarantined.
+type quarantineManifest struct {
+	Source        string    `json:"source"`
+	Target        string    `json:"target"`
+	Attempts      int       `json:"attempts"`
+	Errors        []string  `json:"errors"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// SetQuarantineDir sets the directory to which rotated journals are
+// moved when they can't be converted to ORC and archived.  By default
+// this is a hidden directory alongside the journal.
+func (h *RotatingHandler) SetQuarantineDir(dir string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.quarantineDir = dir
+}
+
+// RetryQuarantined makes one further attempt to convert and archive
+// each quarantined journal.  Journals that are converted are removed
+// from quarantine, the rest stay there with their manifest updated to
+// record the latest failure.  An error is returned if any journal
+// remains in quarantine.
+func (h *RotatingHandler) RetryQuarantined() error {
+	err := h.lockConversion(context.Background())
+	if err != nil {
+		return err
+	}
+	defer h.unlockConversion()
+
+	h.mu.Lock()
+	quarantineDir := h.quarantineDir
+	h.mu.Unlock()
+
+	dirs, err := h.fs.ReadDir(quarantineDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	var lastErr error
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		qdir := filepath.Join(quarantineDir, dir.Name())
+		journalPath := filepath.Join(qdir, workingJournalName)
+		if _, err := h.fs.Stat(journalPath); err != nil {
+			continue
+		}
+		err = h.convertToORC(context.Background(), journalPath)
+		if err == nil {
+			continue
+		}
+		failed++
+		lastErr = err
+		merr := updateQuarantineManifest(h.fs, qdir, err)
+		if merr != nil {
+			log.WithError(merr).WithField("dir", qdir).Error("Unable to update quarantine manifest")
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d quarantined journals could not be converted, last error: %s", failed, lastErr)
+	}
+	return nil
+}
+
+// quarantineJournal moves a journal that couldn't be converted to
+// orcPath into its own subdirectory of quarantineDir, along with a
+// manifest of the failures, and removes the directory it was staged
+// in.
+func quarantineJournal(fsys FS, journalPath, quarantineDir, orcPath string, failures []string) error {
+	err := fsys.MkdirAll(quarantineDir, 0700)
+	if err != nil {
+		return err
+	}
+	qdir, err := tempDir(fsys, quarantineDir, journalDirPrefix)
+	if err != nil {
+		return err
+	}
+	err = moveFile(fsys, journalPath, filepath.Join(qdir, workingJournalName))
+	if err != nil {
+		fsys.Remove(qdir)
+		return err
+	}
+	// Take along the checkpoint and chunks of a chunked conversion,
+	// so it can carry on from where it stopped.
+	dir := filepath.Dir(journalPath)
+	infos, err := fsys.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		err = moveFile(fsys, filepath.Join(dir, info.Name()), filepath.Join(qdir, info.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	manifest := quarantineManifest{
+		Source:        journalPath,
+		Target:        orcPath,
+		Attempts:      len(failures),
+		Errors:        failures,
+		QuarantinedAt: time.Now(),
+	}
+	err = writeQuarantineManifest(fsys, qdir, manifest)
+	if err != nil {
+		return err
+	}
+	return fsys.RemoveAll(filepath.Dir(journalPath))
+}
+
+// updateQuarantineManifest records a further failure in the manifest
+// of the quarantined journal in qdir.
+func updateQuarantineManifest(fsys FS, qdir string, failure error) error {
+	var manifest quarantineManifest
+	b, err := readFile(fsys, filepath.Join(qdir, quarantineManifestName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &manifest)
+		if err != nil {
+			return err
+		}
+	}
+	manifest.Attempts++
+	manifest.Errors = append(manifest.Errors, failure.Error())
+	return writeQuarantineManifest(fsys, qdir, manifest)
+}
+
+func writeQuarantineManifest(fsys FS, qdir string, manifest quarantineManifest) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(fsys, filepath.Join(qdir, quarantineManifestName), b, 0600)
+}
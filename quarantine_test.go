@@ -0,0 +1,149 @@
+package apexorc
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// Conversion is retried, and once the attempts are exhausted the
+// journal is quarantined with a manifest, from where it can be
+// retried later.
+func TestQuarantineAndRetry(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-quarantine")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	archiveErr := errors.New("Archive is full of orcs")
+	var calls int
+	failing := true
+	archiveF := func(oldPath string) error {
+		calls++
+		if failing {
+			return archiveErr
+		}
+		return NumericArchiveF(oldPath)
+	}
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, archiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	rotator.SetConversionRetries(3, time.Millisecond)
+	log.SetHandler(rotator)
+	log.Info("Quarantine me")
+
+	err = rotator.Rotate()
+	if err != archiveErr {
+		t.Fatalf("Expected %q from Rotate, got %v", archiveErr, err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts to archive, got %d", calls)
+	}
+
+	dirs, err := ioutil.ReadDir(rotator.quarantineDir)
+	if err != nil {
+		t.Fatalf("Error reading quarantine directory: %s", err)
+	}
+	if len(dirs) != 1 {
+		t.Fatalf("Expected 1 quarantined journal, found %d", len(dirs))
+	}
+	qdir := filepath.Join(rotator.quarantineDir, dirs[0].Name())
+	if _, err := os.Stat(filepath.Join(qdir, workingJournalName)); err != nil {
+		t.Fatalf("Expected quarantined journal: %s", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(qdir, quarantineManifestName))
+	if err != nil {
+		t.Fatalf("Error reading quarantine manifest: %s", err)
+	}
+	var manifest quarantineManifest
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		t.Fatalf("Error decoding quarantine manifest: %s", err)
+	}
+	if manifest.Attempts != 3 || len(manifest.Errors) != 3 || manifest.Errors[0] != archiveErr.Error() {
+		t.Errorf("Unexpected quarantine manifest %+v", manifest)
+	}
+	if manifest.Target != path {
+		t.Errorf("Expected manifest target %q, got %q", path, manifest.Target)
+	}
+
+	// Still failing, so the journal stays put and the manifest
+	// records another attempt.
+	err = rotator.RetryQuarantined()
+	if err == nil {
+		t.Fatal("Expected an error retrying while archiving still fails")
+	}
+	b, err = ioutil.ReadFile(filepath.Join(qdir, quarantineManifestName))
+	if err != nil {
+		t.Fatalf("Error reading quarantine manifest: %s", err)
+	}
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		t.Fatalf("Error decoding quarantine manifest: %s", err)
+	}
+	if manifest.Attempts != 4 {
+		t.Errorf("Expected 4 attempts in manifest, got %d", manifest.Attempts)
+	}
+
+	failing = false
+	err = rotator.RetryQuarantined()
+	if err != nil {
+		t.Fatalf("Error retrying quarantined journals: %s", err)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("Expected archived ORC file: %s", err)
+	}
+	dirs, err = ioutil.ReadDir(rotator.quarantineDir)
+	if err != nil {
+		t.Fatalf("Error reading quarantine directory: %s", err)
+	}
+	if len(dirs) != 0 {
+		t.Errorf("Expected an empty quarantine directory, found %d entries", len(dirs))
+	}
+}
+
+// When temp files are always removed, failed journals are discarded
+// rather than quarantined.
+func TestAlwaysRemoveTempFilesSkipsQuarantine(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-quarantine-remove")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, func(string) error {
+		return errors.New("No")
+	})
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	rotator.EnableAlwaysRemoveTempFiles()
+	rotator.SetConversionRetries(1, 0)
+	log.SetHandler(rotator)
+	log.Info("Discard me")
+
+	if err = rotator.Rotate(); err == nil {
+		t.Fatal("Expected an error from Rotate")
+	}
+	if _, err := os.Stat(rotator.quarantineDir); !os.IsNotExist(err) {
+		t.Errorf("Expected no quarantine directory")
+	}
+	staged, err := ioutil.ReadDir(rotator.stagingDir)
+	if err != nil {
+		t.Fatalf("Error reading staging directory: %s", err)
+	}
+	if len(staged) != 0 {
+		t.Errorf("Expected an empty staging directory, found %d entries", len(staged))
+	}
+}
@@ -0,0 +1,215 @@
+package apexorc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// The number of entries a query returns unless it asks for a
+// different limit, and the most it may ask for.
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 10000
+)
+
+// QueryHandler is a net/http.Handler that searches a log handled by a
+// RotatingHandler, covering its archives as well as its journals.
+// It's intended to be mounted on an internal or administrative port;
+// it does no authentication of its own.
+//
+// Queries are GET requests taking the following parameters:
+//
+//	from, to   RFC 3339 timestamps bounding the entries returned
+//	since      a duration, such as 2h, used instead of from
+//	level      the minimum level of the entries returned
+//	field      name=value, only returning entries with that field value;
+//	           may be repeated, in which case every field must match
+//	limit      the maximum number of entries to return (default 100)
+//	offset     the number of matching entries to skip
+//	format     json (the default) or ndjson
+//
+// A json response is an object with the matching entries in
+// "entries" and, if there are more, the offset of the next page in
+// "next_offset".  An ndjson response has one entry per line, with the
+// offset of the next page in the X-Next-Offset header.
+type QueryHandler struct {
+	rotator *RotatingHandler
+}
+
+// NewQueryHandler returns a QueryHandler for the log handled by h.
+func NewQueryHandler(h *RotatingHandler) *QueryHandler {
+	return &QueryHandler{rotator: h}
+}
+
+// query is a parsed request to a QueryHandler.
+type query struct {
+	from   time.Time
+	to     time.Time
+	level  log.Level
+	fields map[string]string
+	limit  int
+	offset int
+	ndjson bool
+}
+
+// queryResponse is the body of a json response from a QueryHandler.
+type queryResponse struct {
+	Entries    []*log.Entry `json:"entries"`
+	NextOffset *int         `json:"next_offset,omitempty"`
+}
+
+func parseQuery(r *http.Request) (*query, error) {
+	params := r.URL.Query()
+	q := &query{
+		level:  log.DebugLevel,
+		fields: make(map[string]string),
+		limit:  defaultQueryLimit,
+	}
+	var err error
+
+	if v := params.Get("from"); v != "" {
+		q.from, err = time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %s", err)
+		}
+	}
+	if v := params.Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since: %s", err)
+		}
+		q.from = time.Now().Add(-d)
+	}
+	if v := params.Get("to"); v != "" {
+		q.to, err = time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to: %s", err)
+		}
+	}
+	if v := params.Get("level"); v != "" {
+		q.level, err = log.ParseLevel(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level: %s", err)
+		}
+	}
+	for _, v := range params["field"] {
+		i := strings.IndexByte(v, '=')
+		if i < 1 {
+			return nil, fmt.Errorf("invalid field %q, expected name=value", v)
+		}
+		q.fields[v[:i]] = v[i+1:]
+	}
+	if v := params.Get("limit"); v != "" {
+		q.limit, err = strconv.Atoi(v)
+		if err != nil || q.limit < 1 || q.limit > maxQueryLimit {
+			return nil, fmt.Errorf("invalid limit %q, expected 1 to %d", v, maxQueryLimit)
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		q.offset, err = strconv.Atoi(v)
+		if err != nil || q.offset < 0 {
+			return nil, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	switch params.Get("format") {
+	case "", "json":
+	case "ndjson":
+		q.ndjson = true
+	default:
+		return nil, fmt.Errorf("invalid format %q, expected json or ndjson", params.Get("format"))
+	}
+	return q, nil
+}
+
+// matches returns true if e should be included in the results of q.
+func (q *query) matches(e *log.Entry) bool {
+	if e.Level < q.level {
+		return false
+	}
+	for name, value := range q.fields {
+		v, ok := e.Fields[name]
+		if !ok || fmt.Sprint(v) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP answers a query, as described on QueryHandler.
+func (qh *QueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	set, err := qh.rotator.OpenLogSet(q.from, q.to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer set.Close()
+
+	var entries []*log.Entry
+	var matched int
+	more := false
+	for set.Next() {
+		e := set.Entry()
+		if !q.matches(e) {
+			continue
+		}
+		matched++
+		if matched <= q.offset {
+			continue
+		}
+		if len(entries) == q.limit {
+			more = true
+			break
+		}
+		entries = append(entries, e)
+	}
+	if err = set.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := queryResponse{Entries: entries}
+	if resp.Entries == nil {
+		resp.Entries = []*log.Entry{}
+	}
+	if more {
+		next := q.offset + q.limit
+		resp.NextOffset = &next
+	}
+	if q.ndjson {
+		writeNDJSON(w, resp)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeNDJSON(w http.ResponseWriter, resp queryResponse) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if resp.NextOffset != nil {
+		w.Header().Set("X-Next-Offset", strconv.Itoa(*resp.NextOffset))
+	}
+	enc := json.NewEncoder(w)
+	for _, e := range resp.Entries {
+		if enc.Encode(e) != nil {
+			// The client has gone away.
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package apexorc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func testQuery(t *testing.T, qh *QueryHandler, url string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	rec := httptest.NewRecorder()
+	qh.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestQueryHandler(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-query")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, NumericArchiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	log.SetHandler(rotator)
+	log.WithField("user", "bilbo").Info("One")
+	log.WithField("user", "frodo").Warn("Two")
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	log.WithField("user", "frodo").Error("Three")
+	log.WithField("user", "frodo").Info("Four")
+
+	qh := NewQueryHandler(rotator)
+	cases := []struct {
+		url      string
+		expected []string
+		next     *int
+	}{
+		{"/?", []string{"One", "Two", "Three", "Four"}, nil},
+		{"/?level=warn", []string{"Two", "Three"}, nil},
+		{"/?field=user%3Dfrodo", []string{"Two", "Three", "Four"}, nil},
+		{"/?field=user%3Dfrodo&level=error", []string{"Three"}, nil},
+		{"/?limit=2", []string{"One", "Two"}, new(int)},
+		{"/?limit=2&offset=2", []string{"Three", "Four"}, nil},
+		{"/?since=1h&limit=10", []string{"One", "Two", "Three", "Four"}, nil},
+	}
+	for cid, c := range cases {
+		rec := testQuery(t, qh, c.url)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("[Case %d] Expected status 200, got %d: %s", cid, rec.Code, rec.Body)
+		}
+		var resp queryResponse
+		err = json.Unmarshal(rec.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatalf("[Case %d] Error decoding response: %s", cid, err)
+		}
+		msgs := []string{}
+		for _, e := range resp.Entries {
+			msgs = append(msgs, e.Message)
+		}
+		if !reflect.DeepEqual(msgs, c.expected) {
+			t.Errorf("[Case %d] Expected %q, got %q", cid, c.expected, msgs)
+		}
+		if (c.next == nil) != (resp.NextOffset == nil) {
+			t.Errorf("[Case %d] Expected next offset %v, got %v", cid, c.next, resp.NextOffset)
+		} else if resp.NextOffset != nil && *resp.NextOffset != 2 {
+			t.Errorf("[Case %d] Expected next offset 2, got %d", cid, *resp.NextOffset)
+		}
+	}
+
+	rec := testQuery(t, qh, "/?format=ndjson&limit=3")
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected ndjson content type, got %q", ct)
+	}
+	if next := rec.Header().Get("X-Next-Offset"); next != "3" {
+		t.Errorf("Expected X-Next-Offset 3, got %q", next)
+	}
+	scanner := bufio.NewScanner(rec.Body)
+	var lines int
+	for scanner.Scan() {
+		e := &log.Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			t.Fatalf("Error decoding ndjson line: %s", err)
+		}
+		lines++
+	}
+	if lines != 3 {
+		t.Errorf("Expected 3 ndjson lines, got %d", lines)
+	}
+
+	for _, url := range []string{"/?level=loud", "/?limit=0", "/?from=yesterday", "/?field=user", "/?format=xml"} {
+		if rec := testQuery(t, qh, url); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", url, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package apexorc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/scritchley/orc"
+)
+
+// entrySource is a sequence of log.Entrys read back from a journal or
+// an ORC file.  next returns io.EOF once the sequence is exhausted.
+type entrySource interface {
+	next() (*log.Entry, error)
+	Close() error
+}
+
+// journalSource reads log.Entrys back from a journal.
+type journalSource struct {
+	reader *bufio.Reader
+	closer io.Closer
+}
+
+func newJournalSource(r io.ReadCloser) *journalSource {
+	return &journalSource{reader: bufio.NewReader(r), closer: r}
+}
+
+func (s *journalSource) next() (*log.Entry, error) {
+	for {
+		line, err := s.reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything without a newline is an entry that's
+			// still being written, so we'll ignore it.
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJournalEntry(line)
+		if err != nil {
+			// A damaged line can't be replayed by
+			// convertToORC either, so skip it.
+			continue
+		}
+		return e, nil
+	}
+}
+
+func (s *journalSource) Close() error {
+	return s.closer.Close()
+}
+
+// orcSource reads log.Entrys back from an ORC file written by a
+// Handler.
+type orcSource struct {
+	file    File
+	reader  *orc.Reader
+	cursor  *orc.Cursor
+	started bool
+}
+
+// newORCSource opens the ORC file held open by f.  The file is closed
+// when the orcSource is.  An encrypted file is decrypted, using keys,
+// into memory, as ORC files can't be read sequentially.
+func newORCSource(f File, keys KeyProvider) (*orcSource, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var sr orc.SizedReaderAt = sizedFile{f, info.Size()}
+	if _, encrypted := envelopeKeyID(io.NewSectionReader(f, 0, info.Size())); encrypted {
+		b, err := ioutil.ReadAll(newDecryptingReader(io.NewSectionReader(f, 0, info.Size()), keys))
+		if err != nil {
+			return nil, err
+		}
+		sr = bytes.NewReader(b)
+	}
+	r, err := orc.NewReader(sr)
+	if err != nil {
+		return nil, err
+	}
+	columns := []string{"timestamp", "level", "message", "fields"}
+	for _, column := range r.Schema().Columns() {
+		// Files written before schema version 2 have no
+		// repeat_count or last_timestamp, and before version 3
+		// no field_types.
+		if column == RepeatCountField {
+			columns = append(columns, RepeatCountField, LastTimestampField)
+		}
+	}
+	for _, column := range r.Schema().Columns() {
+		if column == fieldTypesColumn {
+			columns = append(columns, fieldTypesColumn)
+		}
+	}
+	return &orcSource{
+		file:   f,
+		reader: r,
+		cursor: r.Select(columns...),
+	}, nil
+}
+
+func (s *orcSource) next() (*log.Entry, error) {
+	for {
+		if s.started && s.cursor.Next() {
+			return entryFromRow(s.cursor.Row()), nil
+		}
+		if !s.cursor.Stripes() {
+			if err := s.cursor.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		s.started = true
+	}
+}
+
+func (s *orcSource) Close() error {
+	err := s.reader.Close()
+	// The orc.Reader may or may not have closed the file for us.
+	if cerr := s.file.Close(); err == nil && !errors.Is(cerr, os.ErrClosed) {
+		err = cerr
+	}
+	return err
+}
+
+// sizedFile lets a File satisfy orc.SizedReaderAt.
+type sizedFile struct {
+	File
+	size int64
+}
+
+func (f sizedFile) Size() int64 {
+	return f.size
+}
+
+// entryFromRow is the reverse of writeRecord, building a log.Entry
+// from a row of the timestamp, level, message and fields columns,
+// optionally followed by the repeat_count and last_timestamp columns
+// and then the field_types column, which gives fields back the types
+// they were logged with.
+func entryFromRow(row []interface{}) *log.Entry {
+	e := &log.Entry{Fields: log.Fields{}}
+	e.Timestamp, _ = row[0].(time.Time)
+	if level, ok := row[1].(string); ok {
+		e.Level, _ = log.ParseLevel(level)
+	}
+	e.Message, _ = row[2].(string)
+	fields, _ := row[3].([]orc.MapEntry)
+	for _, field := range fields {
+		k, ok := field.Key.(string)
+		if !ok {
+			continue
+		}
+		e.Fields[k] = field.Value
+	}
+	if len(row) > 6 {
+		types, _ := row[6].([]orc.MapEntry)
+		for _, t := range types {
+			k, _ := t.Key.(string)
+			str, ok := e.Fields[k].(string)
+			if !ok {
+				continue
+			}
+			typ, _ := t.Value.(string)
+			e.Fields[k] = parseField(str, typ)
+		}
+	}
+	if len(row) > 5 {
+		if count, _ := row[4].(int64); count > 1 {
+			last, _ := row[5].(time.Time)
+			e.Fields[RepeatCountField] = repeatCount(count)
+			e.Fields[LastTimestampField] = repeatLast{last}
+		}
+	}
+	return e
+}
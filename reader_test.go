@@ -0,0 +1,94 @@
+package apexorc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func TestJournalSource(t *testing.T) {
+	journal := `{"fields":{"k":"v"},"level":"info","timestamp":"2017-01-02T03:04:05Z","message":"one"}
+not json
+{"fields":{},"level":"error","timestamp":"2017-01-02T03:04:06Z","message":"two"}
+{"fields":{},"level":"info","timest`
+	src := newJournalSource(ioutil.NopCloser(bytes.NewBufferString(journal)))
+	defer src.Close()
+
+	for _, expected := range []string{"one", "two"} {
+		e, err := src.next()
+		if err != nil {
+			t.Fatalf("Error reading journal: %s", err)
+		}
+		if e.Message != expected {
+			t.Errorf("Expected %q, got %q", expected, e.Message)
+		}
+	}
+	// The partial entry at the end is still being written.
+	if _, err := src.next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+// Entries read back from an ORC file should match those written.
+func TestORCSource(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-reader")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	handler := NewHandler(path)
+	entries := []*log.Entry{
+		makeTestEntry("morning", log.Fields{"shoes": "brogues"}, nil),
+		makeTestEntry("evening", nil, nil),
+	}
+	for _, e := range entries {
+		err = handler.HandleLog(e)
+		if err != nil {
+			t.Fatalf("Error logging: %s", err)
+		}
+	}
+	err = handler.Close()
+	if err != nil {
+		t.Fatalf("Error closing handler: %s", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Error opening ORC file: %s", err)
+	}
+	src, err := newORCSource(f, nil)
+	if err != nil {
+		t.Fatalf("Error creating ORC source: %s", err)
+	}
+	defer src.Close()
+	for _, expected := range entries {
+		e, err := src.next()
+		if err != nil {
+			t.Fatalf("Error reading ORC file: %s", err)
+		}
+		if e.Message != expected.Message || e.Level != expected.Level {
+			t.Errorf("Expected %s %q, got %s %q", expected.Level, expected.Message, e.Level, e.Message)
+		}
+		if !e.Timestamp.Equal(expected.Timestamp) {
+			t.Errorf("Expected %v, got %v", expected.Timestamp, e.Timestamp)
+		}
+		if len(e.Fields) != len(expected.Fields) {
+			t.Errorf("Expected fields %v, got %v", expected.Fields, e.Fields)
+		}
+		for k := range expected.Fields {
+			if e.Fields.Get(k) != expected.Fields.Get(k) {
+				t.Errorf("Expected %q for field %q, got %q", expected.Fields.Get(k), k, e.Fields.Get(k))
+			}
+		}
+	}
+	if _, err := src.next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package apexorc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/apex/log"
+)
+
+// RedactAction is what a RedactionRule does to the values it matches.
+type RedactAction int
+
+const (
+	// RedactDrop removes a matching field, or the matching part of
+	// a message, entirely.
+	RedactDrop RedactAction = iota
+	// RedactMask replaces a matching value with RedactedMask.
+	RedactMask
+	// RedactHMAC replaces a matching value with a keyed HMAC-SHA256
+	// of it, so equal values can still be correlated without being
+	// revealed.
+	RedactHMAC
+)
+
+// RedactedMask is the value that RedactMask replaces values with.
+const RedactedMask = "[REDACTED]"
+
+// RedactionRule selects values to be redacted from log entries.
+// Field is a field name, or a glob as understood by path.Match, and
+// the rule applies to the whole value of every matching field.
+// Message is a regular expression, and the rule applies to every
+// part of an entry's message that it matches.  Either may be left
+// empty.
+type RedactionRule struct {
+	Field   string
+	Message *regexp.Regexp
+	Action  RedactAction
+}
+
+// RedactionCounts reports how many values a Redactor has redacted,
+// by action.
+type RedactionCounts struct {
+	Dropped uint64
+	Masked  uint64
+	Hashed  uint64
+}
+
+// Redactor applies RedactionRules to log entries before they are
+// persisted, so that values such as email addresses and tokens never
+// reach a journal or ORC file.  A Redactor may be shared by several
+// handlers.
+type Redactor struct {
+	rules  []RedactionRule
+	key    []byte
+	counts [3]uint64 // counts is indexed by RedactAction.
+}
+
+// NewRedactor returns a Redactor applying rules in order.  key is the
+// secret used by RedactHMAC, and may be nil if no rule uses it.
+func NewRedactor(key []byte, rules ...RedactionRule) (*Redactor, error) {
+	for _, rule := range rules {
+		if rule.Field != "" {
+			if _, err := path.Match(rule.Field, ""); err != nil {
+				return nil, fmt.Errorf("invalid field pattern %q: %s", rule.Field, err)
+			}
+		}
+		if rule.Action < RedactDrop || rule.Action > RedactHMAC {
+			return nil, fmt.Errorf("rule for %q has unknown action %d", rule.Field, rule.Action)
+		}
+		if rule.Action == RedactHMAC && len(key) == 0 {
+			return nil, fmt.Errorf("rule for %q hashes values, but no key was given", rule.Field)
+		}
+	}
+	return &Redactor{rules: rules, key: key}, nil
+}
+
+// Counts returns the number of values redacted so far.
+func (r *Redactor) Counts() RedactionCounts {
+	return RedactionCounts{
+		Dropped: atomic.LoadUint64(&r.counts[RedactDrop]),
+		Masked:  atomic.LoadUint64(&r.counts[RedactMask]),
+		Hashed:  atomic.LoadUint64(&r.counts[RedactHMAC]),
+	}
+}
+
+// Redact returns e with the rules applied.  The entry passed in is
+// never modified, as other handlers may see it too; if anything needs
+// redacting a copy is returned instead.
+func (r *Redactor) Redact(e *log.Entry) *log.Entry {
+	var out *log.Entry
+	copyEntry := func() {
+		if out != nil {
+			return
+		}
+		c := *e
+		c.Fields = make(log.Fields, len(e.Fields))
+		for k, v := range e.Fields {
+			c.Fields[k] = v
+		}
+		out = &c
+	}
+
+	for _, rule := range r.rules {
+		if rule.Field != "" {
+			for name, value := range e.Fields {
+				if matched, _ := path.Match(rule.Field, name); !matched {
+					continue
+				}
+				if out != nil {
+					if _, ok := out.Fields[name]; !ok {
+						// An earlier rule dropped it.
+						continue
+					}
+					value = out.Fields[name]
+				}
+				copyEntry()
+				if rule.Action == RedactDrop {
+					delete(out.Fields, name)
+				} else {
+					out.Fields[name] = r.replacement(rule.Action, fmt.Sprint(value))
+				}
+				r.count(rule.Action)
+			}
+		}
+		if rule.Message != nil {
+			msg := e.Message
+			if out != nil {
+				msg = out.Message
+			}
+			if !rule.Message.MatchString(msg) {
+				continue
+			}
+			copyEntry()
+			out.Message = rule.Message.ReplaceAllStringFunc(msg, func(match string) string {
+				r.count(rule.Action)
+				if rule.Action == RedactDrop {
+					return ""
+				}
+				return r.replacement(rule.Action, match)
+			})
+		}
+	}
+	if out == nil {
+		return e
+	}
+	return out
+}
+
+func (r *Redactor) count(action RedactAction) {
+	atomic.AddUint64(&r.counts[action], 1)
+}
+
+// replacement returns what value is replaced with by action, which is
+// never RedactDrop.
+func (r *Redactor) replacement(action RedactAction, value string) string {
+	if action == RedactMask {
+		return RedactedMask
+	}
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,129 @@
+package apexorc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func TestRedact(t *testing.T) {
+	email := regexp.MustCompile(`[a-z]+@[a-z.]+`)
+	r, err := NewRedactor([]byte("secret"),
+		RedactionRule{Field: "password", Action: RedactDrop},
+		RedactionRule{Field: "*_token", Action: RedactMask},
+		RedactionRule{Field: "ip", Action: RedactHMAC},
+		RedactionRule{Message: email, Action: RedactMask},
+	)
+	if err != nil {
+		t.Fatalf("Error creating redactor: %s", err)
+	}
+
+	e := &log.Entry{
+		Message: "Sent to bilbo@shire.me and frodo@shire.me",
+		Fields: log.Fields{
+			"password":      "hunter2",
+			"session_token": "abc",
+			"ip":            "10.0.0.1",
+			"user":          "bilbo",
+		},
+	}
+	redacted := r.Redact(e)
+
+	if redacted == e || e.Fields["password"] != "hunter2" || !strings.Contains(e.Message, "bilbo@") {
+		t.Fatal("Expected the original entry to be left alone")
+	}
+	if _, ok := redacted.Fields["password"]; ok {
+		t.Error("Expected password to be dropped")
+	}
+	if redacted.Fields["session_token"] != RedactedMask {
+		t.Errorf("Expected session_token to be masked, got %v", redacted.Fields["session_token"])
+	}
+	ip, _ := redacted.Fields["ip"].(string)
+	if !strings.HasPrefix(ip, "hmac-sha256:") || strings.Contains(ip, "10.0.0.1") {
+		t.Errorf("Expected ip to be hashed, got %q", ip)
+	}
+	// The same value always hashes the same way.
+	again := r.Redact(&log.Entry{Fields: log.Fields{"ip": "10.0.0.1"}})
+	if again.Fields["ip"] != ip {
+		t.Errorf("Expected %q, got %v", ip, again.Fields["ip"])
+	}
+	if redacted.Fields["user"] != "bilbo" {
+		t.Errorf("Expected user to be left alone, got %v", redacted.Fields["user"])
+	}
+	expected := "Sent to [REDACTED] and [REDACTED]"
+	if redacted.Message != expected {
+		t.Errorf("Expected message %q, got %q", expected, redacted.Message)
+	}
+
+	counts := r.Counts()
+	if counts != (RedactionCounts{Dropped: 1, Masked: 3, Hashed: 2}) {
+		t.Errorf("Unexpected counts %+v", counts)
+	}
+
+	clean := &log.Entry{Message: "Nothing to see", Fields: log.Fields{"user": "sam"}}
+	if r.Redact(clean) != clean {
+		t.Error("Expected an entry needing no redaction to be returned as is")
+	}
+
+	if _, err := NewRedactor(nil, RedactionRule{Field: "ip", Action: RedactHMAC}); err == nil {
+		t.Error("Expected an error hashing without a key")
+	}
+	if _, err := NewRedactor(nil, RedactionRule{Field: "[", Action: RedactDrop}); err == nil {
+		t.Error("Expected an error for a bad field pattern")
+	}
+	for _, action := range []RedactAction{-1, RedactHMAC + 1} {
+		if _, err := NewRedactor(nil, RedactionRule{Field: "ip", Action: action}); err == nil {
+			t.Errorf("Expected an error for action %d", action)
+		}
+	}
+}
+
+// Redacted values must never reach the journal.
+func TestRotatingHandlerRedacts(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-redact")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, NumericArchiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	r, err := NewRedactor(nil, RedactionRule{Field: "password", Action: RedactMask})
+	if err != nil {
+		t.Fatalf("Error creating redactor: %s", err)
+	}
+	rotator.SetRedactor(r)
+	log.SetHandler(rotator)
+	log.WithField("password", "hunter2").Info("Logged in")
+
+	f, err := os.Open(rotator.journalPath)
+	if err != nil {
+		t.Fatalf("Error opening journal: %s", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("Expected an entry in the journal")
+	}
+	if strings.Contains(scanner.Text(), "hunter2") {
+		t.Errorf("Password reached the journal: %s", scanner.Text())
+	}
+	e := &log.Entry{}
+	err = json.Unmarshal(scanner.Bytes(), e)
+	if err != nil {
+		t.Fatalf("Error decoding journal entry: %s", err)
+	}
+	if e.Fields["password"] != RedactedMask {
+		t.Errorf("Expected a masked password, got %v", e.Fields["password"])
+	}
+}
@@ -2,14 +2,18 @@ package apexorc
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/apex/log"
 )
@@ -52,7 +56,8 @@ type CloserHandler interface {
 // ensure that files are moved non-destructively, but the
 // RotatingHandler guarantees that the file will be closed before an
 // ArchvieFunc is called, and that no attemp to log will be made until
-// after it has completed its work.
+// after it has completed its work.  The file's Manifest, at
+// ManifestPath(oldPath), should be moved along with it.
 type ArchiveFunc func(oldPath string) error
 
 // RotatingHandler is a github.com/apex/log.Handler implementation
@@ -62,108 +67,307 @@ type ArchiveFunc func(oldPath string) error
 // using the NewRotatingHandler function.
 type RotatingHandler struct {
 	alwaysRemoveTempFiles bool
+	direct                bool
+	formats               []OutputFormat
 
 	mu sync.Mutex // mu is the Mutex that is used in all
 	// apex log handlers, it prevents
 	// out-of-order logging.
 
-	cmu sync.Mutex // cmu is a Mutex that protect the
-	// process of converting a rotated log
-	// journal into an ORC file.  By
-	// separating this from the rotation
+	converting chan struct{} // converting is a semaphore that
+	// protects the process of converting a
+	// rotated log journal into an ORC file.
+	// By separating this from the rotation
 	// itself we can allow logging to
 	// continue as soon as we've moved the
-	// journal to a rotated position.
-	journalPath string
-	path        string
-	handler     CloserHandler
-	archiveF    ArchiveFunc
+	// journal to a rotated position.  It is
+	// a channel, not a Mutex, so waiting
+	// for it can be cancelled.
+	journalPath   string
+	stagingDir    string
+	quarantineDir string
+	path          string
+	handler       CloserHandler
+	archiveF      ArchiveFunc
+	rotateErr     error // rotateErr is the reason the last rotation failed.
+	retryAttempts int
+	retryBackoff  time.Duration
+	redactor      *Redactor
+	keys          KeyProvider
+	fs            FS
+
+	pidSuffixOnLock bool
+	lock            File // lock is held for as long as we log to path.
+	closed          bool // closed is set by Close.
+	verify          bool
+	chunkRows       int
+}
+
+// The default number of attempts, and the initial delay between them,
+// made to convert and archive a rotated journal before giving up and
+// quarantining it.
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = time.Second
+)
+
+// Each rotated journal is moved to a directory of its own, named with
+// journalDirPrefix, where it is given the name workingJournalName.
+// Alongside it, rotationInfoName records where it came from, and
+// archivedName which of the files converted from it have been
+// archived.
+const (
+	journalDirPrefix   = "avocet-journal-"
+	workingJournalName = "working.jrnl"
+	rotationInfoName   = "rotation.json"
+	archivedName       = "archived.json"
+)
+
+// Option configures a RotatingHandler at construction time.  Options
+// are passed to NewRotatingHandler.
+type Option func(*RotatingHandler)
+
+// DirectORC is an Option that makes the RotatingHandler write log
+// entries straight into an in-progress ORC file using a subordinate
+// Handler, rather than to a JSON journal that is converted on
+// rotation.  Rotation then only has to finalise the current ORC file
+// and start a new one.
+//
+// This avoids writing every entry twice, and keeps field values out
+// of the JSON round trip, but an ORC file has no footer until it is
+// closed, so a crash will lose everything written since the last
+// rotation.
+func DirectORC() Option {
+	return func(h *RotatingHandler) {
+		h.direct = true
+	}
+}
+
+// WithFS is an Option that makes the RotatingHandler keep its journal,
+// and the files it converts it to, on fsys rather than the local
+// filesystem.  The ArchiveFunc should use the same FS; see
+// NumericArchiveFunc.
+func WithFS(fsys FS) Option {
+	return func(h *RotatingHandler) {
+		h.fs = fsys
+	}
+}
+
+// Encrypted is an Option that encrypts the journal, and every file
+// that is archived, with keys from kp.  Each file has a data key of
+// its own, which is stored in the file wrapped by kp's current key,
+// along with that key's ID, so the current key can be changed at any
+// time as long as kp can still supply the old ones.  The key ID is
+// also recorded in each file's Manifest.  Reading a file fails if its
+// frames have been reordered, repeated or dropped, or if an archived
+// file has been truncated.
+//
+// Use OpenEncryptedLogSet, FollowEncrypted or DecryptFile to read the
+// files back.
+func Encrypted(kp KeyProvider) Option {
+	return func(h *RotatingHandler) {
+		h.keys = kp
+	}
 }
 
 // NewRotatingHandler returns an instance of the RotatingHandler with
 // a subordinate ORC Handler logging to the provided path.  Should
 // Rotate be called then the provided ArchiveFunc will be used to move
-// the current ORC log file out of the way before creating a new one
-// at the same path and continuing to handle log entries.
-func NewRotatingHandler(path string, archiveF ArchiveFunc) (*RotatingHandler, error) {
-	journalPath := makeJournalPathFromPath(path)
-	handler, err := newJournalHandlerForPath(journalPath)
-	return &RotatingHandler{
-		journalPath: journalPath,
-		path:        path,
-		handler:     handler,
-		archiveF:    archiveF,
-	}, err
+// the current ORC log file, and any other files written as a result
+// of the OutputFormats option, out of the way before creating a new
+// one at the same path and continuing to handle log entries.
+//
+// The RotatingHandler holds an exclusive lock on the log, through a
+// hidden lock file alongside the journal, for as long as the process
+// runs.  If another process already holds it a LockedError is
+// returned, unless the PIDSuffixOnLock option is given.
+func NewRotatingHandler(path string, archiveF ArchiveFunc, opts ...Option) (*RotatingHandler, error) {
+	h := &RotatingHandler{
+		archiveF:      archiveF,
+		retryAttempts: defaultRetryAttempts,
+		retryBackoff:  defaultRetryBackoff,
+		formats:       []OutputFormat{FormatORC},
+		fs:            OSFS{},
+		converting:    make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	err := checkFormats(h.formats)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := lockLog(h.fs, path)
+	if IsLockedError(err) && h.pidSuffixOnLock {
+		path = makePIDPathFromPath(path, os.Getpid())
+		lock, err = lockLog(h.fs, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	h.lock = lock
+	h.path = path
+	h.journalPath = makeJournalPathFromPath(path)
+	h.stagingDir = makeStagingDirFromPath(path)
+	h.quarantineDir = makeQuarantineDirFromPath(path)
+
+	if h.direct {
+		h.handler = fanOutHandler(newFileHandlers(h.fs, path, h.formats, h.keys))
+		return h, nil
+	}
+	err = h.stageLeftoverJournal()
+	if err != nil {
+		h.unlock()
+		return nil, err
+	}
+	handler, err := newJournalHandlerForPath(h.fs, h.journalPath, h.keys)
+	if err != nil {
+		h.unlock()
+		return nil, err
+	}
+	h.handler = handler
+	return h, nil
 }
 
 // EnableAlwaysRemoveTempFiles ensures that we always remove temp files even if we were
-// unable to convert to ORC
+// unable to convert to ORC, rather than quarantining the journal.
 func (h *RotatingHandler) EnableAlwaysRemoveTempFiles() {
 	h.alwaysRemoveTempFiles = true
 }
 
+// SetStagingDir sets the directory in which rotated journals are kept
+// while they are converted to ORC.  By default this is a hidden
+// directory alongside the journal.  Each rotation uses its own
+// subdirectory, which is removed once conversion is complete.
+//
+// Journals are moved into the staging directory by renaming them, so
+// it should be on the same filesystem as the journal.  Should it not
+// be, the journal is copied instead, which blocks logging for longer.
+func (h *RotatingHandler) SetStagingDir(dir string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stagingDir = dir
+}
+
+// SetConversionRetries sets how many attempts are made to convert and
+// archive each rotated journal, and how long to wait after the first
+// failure.  The wait doubles after each subsequent failure.  Rotate
+// doesn't return until the last attempt has been made, after which a
+// journal that still couldn't be converted is quarantined.
+func (h *RotatingHandler) SetConversionRetries(attempts int, backoff time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if attempts < 1 {
+		attempts = 1
+	}
+	h.retryAttempts = attempts
+	h.retryBackoff = backoff
+}
+
+// SetRedactor sets a Redactor to be applied to every entry before it
+// is written to the journal, or to the ORC file in direct mode, so
+// redacted values never reach the disk.
+func (h *RotatingHandler) SetRedactor(r *Redactor) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.redactor = r
+}
+
 // HandleLog passes logging duty through to the subordinate ORC Handler.
 func (h *RotatingHandler) HandleLog(e *log.Entry) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if h.closed {
+		return ErrHandlerClosed
+	}
+	if h.redactor != nil {
+		e = h.redactor.Redact(e)
+	}
+
+	if h.handler == nil {
+		// Rotation left us without a journal, try again to
+		// open one.
+		handler, err := openJournalHandlerForPath(h.fs, h.journalPath, h.keys)
+		if err != nil {
+			return err
+		}
+		h.handler = handler
+	}
 	return h.handler.HandleLog(e)
 }
 
-// Convert a journal file into an ORC file.  The intent is that this
-// should only happen once all logging activity on the journal file is
-// completed.
-func (h *RotatingHandler) convertToORC(journalPath, orcPath string) error {
-	h.cmu.Lock() // We lock out further conversion processes until
-	// this one is finished.  The conusmer of this library is
-	// expected to take care that calls to Rotate() usually happen
-	// at intervals that exceed the time taken to completee
-	// conversion so that a backlog of conversion processes
-	// doesn't build-up.
-	defer h.cmu.Unlock()
-
+// Convert a journal file into an ORC file and archive it.  The intent
+// is that this should only happen once all logging activity on the
+// journal file is completed.  The caller must hold the conversion
+// lock.  The journal, along with the directory containing it, is
+// removed once the ORC file has been archived.
+//
+// Should ctx end first, the partly written files are discarded, the
+// journal is left where it is and ctx's error is returned.
+//
+// With the ChunkedConversion option, or if an earlier attempt left a
+// checkpoint, the journal is converted and archived a chunk at a time
+// instead, carrying on from the checkpoint; see convertChunks.
+func (h *RotatingHandler) convertToORC(ctx context.Context, journalPath string) error {
 	logCtx := log.WithFields(
 		log.Fields{
 			"journalPath": journalPath,
 			"function":    "convertToORC",
 		})
 
-	if h.alwaysRemoveTempFiles {
-		defer func() {
-			err := os.RemoveAll(path.Base(journalPath))
-			if err != nil {
-				logCtx.WithError(err).Error("Unable to remove temporary journal")
-			}
-		}()
+	cp, err := readCheckpoint(h.fs, filepath.Dir(journalPath))
+	if err != nil {
+		return err
+	}
+	if cp != nil || h.chunkRows > 0 {
+		return h.convertChunks(ctx, journalPath, cp, logCtx)
 	}
 
-	f, err := os.Open(journalPath)
+	// An earlier attempt may have archived some of the files before
+	// failing to archive the rest.
+	dir := filepath.Dir(journalPath)
+	archived, err := readArchived(h.fs, dir)
 	if err != nil {
 		return err
 	}
-
-	orchandler := NewHandler(orcPath)
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		// Note, per line error are logged, but otherwise
-		// ignored - we want to convert every line we can.
-		e := &log.Entry{}
-		err := json.Unmarshal(scanner.Bytes(), e)
-		if err != nil {
-			logCtx.WithError(err).WithField("str", scanner.Text()).Error("Error unmarshalling during play back of journal")
+	var outputs fanOutHandler
+	for _, out := range newFileHandlers(h.fs, h.path, h.formats, h.keys) {
+		if !archived[filepath.Base(out.filePath())] {
+			outputs = append(outputs, out)
 		}
-		err = orchandler.HandleLog(e)
-		if err != nil {
-			logCtx.WithError(err).Error("Error writing log entry to ORC")
+	}
+	if len(outputs) == 0 {
+		if len(archived) == 0 {
+			return fmt.Errorf("apexorc: no output formats to convert %s to", journalPath)
 		}
+		// Every file was archived by an earlier attempt.
+		return h.fs.RemoveAll(dir)
 	}
 
-	if err = scanner.Err(); err != nil {
+	f, err := h.fs.Open(journalPath)
+	if err != nil {
+		return err
+	}
+
+	// The output handlers only rename their files into place once
+	// they are complete, so the ArchiveFunc never sees a partial
+	// file.
+	var replayed entryStats
+	err = replayJournal(ctx, newDecryptingReader(f, h.keys), decodeJournalEntry, outputs, logCtx, &replayed)
+	if ctx.Err() != nil {
+		outputs.discard()
+		f.Close()
+		return ctx.Err()
+	}
+	if err != nil {
 		logCtx.WithError(err).Error("Error scanning journal")
 	}
 
-	err = orchandler.Close()
+	err = outputs.Close()
 	if err != nil {
+		f.Close()
 		logCtx.WithError(err).Error("Error closing the ORC file")
 		return err
 	}
@@ -173,88 +377,739 @@ func (h *RotatingHandler) convertToORC(journalPath, orcPath string) error {
 		return err
 	}
 
-	if !h.alwaysRemoveTempFiles {
-		err = os.RemoveAll(path.Base(journalPath))
+	if h.verify {
+		err = h.verifyOutputs(outputs, replayed)
 		if err != nil {
-			logCtx.WithError(err).Error("Unable to remove temporary journal")
+			logCtx.WithError(err).Error("Error verifying the ORC file")
+			return err
 		}
 	}
 
-	err = h.archiveF(orcPath)
+	err = h.archiveOutputs(outputs, h.journalOrigin(journalPath), dir)
 	if err != nil {
 		logCtx.WithError(err).Error("Error archiving ORC file")
 		return err
 	}
 
+	err = h.fs.RemoveAll(dir)
+	if err != nil {
+		logCtx.WithError(err).Error("Unable to remove temporary journal")
+	}
+	return nil
+}
+
+// convertChunks converts and archives the journal at journalPath a
+// chunk of h.chunkRows entries at a time, starting from the checkpoint
+// cp, which is nil if there isn't one yet.  Each chunk is written in
+// the journal's directory, then archived by archiveChunk, and the
+// checkpoint moved on past it.  The caller must hold the conversion
+// lock.
+func (h *RotatingHandler) convertChunks(ctx context.Context, journalPath string, cp *conversionCheckpoint, logCtx log.Interface) error {
+	dir := filepath.Dir(journalPath)
+	info := h.journalOrigin(journalPath)
+	if cp == nil {
+		cp = &conversionCheckpoint{StagingID: info.StagingID}
+	}
+	info.StagingID = cp.StagingID
+	if cp.Pending != nil {
+		// We were interrupted archiving a chunk.
+		err := h.archiveChunk(dir, cp.StagingID)
+		if err != nil {
+			logCtx.WithError(err).Error("Error archiving ORC file")
+			return err
+		}
+		cp.Offset, cp.Pending = *cp.Pending, nil
+		err = writeCheckpoint(h.fs, dir, cp)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := h.fs.Open(journalPath)
+	if err != nil {
+		return err
+	}
+	r := newDecryptingReader(f, h.keys)
+	err = skipConverted(h.fs, dir, h.path, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	scanner := newJournalScanner(r)
+	chunkPath := filepath.Join(dir, filepath.Base(h.path))
+	for eof := false; !eof; {
+		outputs := fanOutHandler(newFileHandlers(h.fs, chunkPath, h.formats, h.keys))
+		var n int64
+		var replayed entryStats
+		n, eof, err = replayLines(ctx, scanner, decodeJournalEntry, outputs, logCtx, h.chunkRows, &replayed)
+		if ctx.Err() != nil {
+			outputs.discard()
+			f.Close()
+			return ctx.Err()
+		}
+		if err != nil {
+			logCtx.WithError(err).Error("Error scanning journal")
+			eof = true
+		}
+
+		err = outputs.Close()
+		if err != nil {
+			f.Close()
+			logCtx.WithError(err).Error("Error closing the ORC file")
+			return err
+		}
+		if h.verify {
+			err = h.verifyOutputs(outputs, replayed)
+			if err != nil {
+				f.Close()
+				logCtx.WithError(err).Error("Error verifying the ORC file")
+				return err
+			}
+		}
+		err = h.writeManifests(outputs, info)
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		end := cp.Offset + n
+		cp.Pending = &end
+		err = writeCheckpoint(h.fs, dir, cp)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		err = h.archiveChunk(dir, cp.StagingID)
+		if err != nil {
+			f.Close()
+			logCtx.WithError(err).Error("Error archiving ORC file")
+			return err
+		}
+		cp.Offset, cp.Pending = end, nil
+		err = writeCheckpoint(h.fs, dir, cp)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		logCtx.WithError(err).Error("Error closing the journal")
+		return err
+	}
+
+	err = h.fs.RemoveAll(dir)
+	if err != nil {
+		logCtx.WithError(err).Error("Unable to remove temporary journal")
+	}
 	return nil
 }
 
-// Rotate is a blocking call and will not return until an ORC file has
+// writeManifests writes a manifest for each file written by outputs.
+func (h *RotatingHandler) writeManifests(outputs fanOutHandler, info rotationInfo) error {
+	for _, out := range outputs {
+		path := out.filePath()
+		_, err := h.fs.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = writeManifest(h.fs, path, out.entryStats(), info)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveOutputs writes a manifest for each file written by outputs
+// and passes it to the ArchiveFunc, then updates the catalog.  The
+// handlers don't create their files until the first entry arrives, so
+// there may be nothing to archive.  Unless dir is empty, each file
+// archived is recorded in dir, the directory of the journal it was
+// converted from, so that a retry doesn't archive it again.
+func (h *RotatingHandler) archiveOutputs(outputs fanOutHandler, info rotationInfo, dir string) error {
+	var archived map[string]bool
+	if dir != "" {
+		var err error
+		archived, err = readArchived(h.fs, dir)
+		if err != nil {
+			return err
+		}
+	}
+	var done bool
+	for _, out := range outputs {
+		path := out.filePath()
+		_, err := h.fs.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = writeManifest(h.fs, path, out.entryStats(), info)
+		if err != nil {
+			return err
+		}
+		err = h.archiveF(path)
+		if err != nil {
+			return err
+		}
+		done = true
+		if archived != nil {
+			archived[filepath.Base(path)] = true
+			err = writeArchived(h.fs, dir, archived)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !done {
+		return nil
+	}
+	err := updateCatalog(h.fs, h.path)
+	if err != nil {
+		log.WithError(err).WithField("function", "archiveOutputs").Error("Error updating the catalog")
+	}
+	return nil
+}
+
+// readArchived returns the names of the files recorded in dir, the
+// directory of a rotated journal, as already archived.
+func readArchived(fsys FS, dir string) (map[string]bool, error) {
+	archived := make(map[string]bool)
+	b, err := readFile(fsys, filepath.Join(dir, archivedName))
+	if os.IsNotExist(err) {
+		return archived, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	err = json.Unmarshal(b, &names)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		archived[name] = true
+	}
+	return archived, nil
+}
+
+// writeArchived records the names of the files in archived in dir.
+// Like a checkpoint, it is written to a temporary file first, so it is
+// never seen partially written.
+func writeArchived(fsys FS, dir string, archived map[string]bool) error {
+	names := make([]string, 0, len(archived))
+	for name := range archived {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, archivedName)
+	tmpPath := makeTempPathFromPath(path)
+	err = writeFile(fsys, tmpPath, b, 0600)
+	if err != nil {
+		return err
+	}
+	return fsys.Rename(tmpPath, path)
+}
+
+// maxJournalLine is the longest line that replayJournal will read.
+const maxJournalLine = 16 * 1024 * 1024
+
+// replayJournal decodes each line read from r into a log.Entry using
+// decode, and passes it to handler.  Note, per line error are logged,
+// but otherwise ignored - we want to convert every line we can.  An
+// error is only returned if r itself can't be read, or if ctx ends
+// before r has been read to the end.  Each entry decoded is added to
+// stats, if it isn't nil, whether or not handler managed to write it.
+func replayJournal(ctx context.Context, r io.Reader, decode func([]byte) (*log.Entry, error), handler log.Handler, logCtx log.Interface, stats *entryStats) error {
+	_, _, err := replayLines(ctx, newJournalScanner(r), decode, handler, logCtx, 0, stats)
+	return err
+}
+
+// newJournalScanner returns a bufio.Scanner for the lines of a
+// journal read from r.
+func newJournalScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxJournalLine)
+	return scanner
+}
+
+// replayLines is replayJournal for at most limit lines of scanner, or
+// all of them if limit isn't positive.  It returns the number of bytes
+// of the journal read, which always ends at the end of a line, and
+// whether the end of the journal was reached.
+func replayLines(ctx context.Context, scanner *bufio.Scanner, decode func([]byte) (*log.Entry, error), handler log.Handler, logCtx log.Interface, limit int, stats *entryStats) (int64, bool, error) {
+	var n int64
+	for lines := 0; limit <= 0 || lines < limit; lines++ {
+		if !scanner.Scan() {
+			return n, true, scanner.Err()
+		}
+		// Journals only ever end lines with a bare newline.
+		n += int64(len(scanner.Bytes())) + 1
+		if err := ctx.Err(); err != nil {
+			return n, false, err
+		}
+		e, err := decode(scanner.Bytes())
+		if err != nil {
+			logCtx.WithError(err).WithField("str", scanner.Text()).Error("Error unmarshalling during play back of journal")
+			continue
+		}
+		if stats != nil {
+			stats.add(e)
+		}
+		err = handler.HandleLog(e)
+		if err != nil {
+			logCtx.WithError(err).Error("Error writing log entry to ORC")
+		}
+	}
+	return n, false, nil
+}
+
+// convertStaged converts and archives every journal in the staging
+// directory, oldest first, ending with workingPath if it is still
+// there.  Journals are left in the staging directory when an earlier
+// conversion was cancelled, or when the process exited before
+// converting them, and are picked up here by the next rotation.  Only
+// the error for workingPath is returned; failures converting older
+// journals are logged.  Should ctx end, conversion stops and ctx's
+// error is returned.
+func (h *RotatingHandler) convertStaged(ctx context.Context, workingPath string) error {
+	// We lock out further conversion processes until this one is
+	// finished.  The conusmer of this library is expected to take
+	// care that calls to Rotate() usually happen at intervals that
+	// exceed the time taken to completee conversion so that a
+	// backlog of conversion processes doesn't build-up.
+	err := h.lockConversion(ctx)
+	if err != nil {
+		return err
+	}
+	defer h.unlockConversion()
+
+	h.mu.Lock()
+	stagingDir := h.stagingDir
+	h.mu.Unlock()
+	journals, err := stagedJournals(h.fs, stagingDir)
+	if err != nil {
+		log.WithError(err).WithField("function", "convertStaged").Error("Unable to list staged journals")
+		journals = []string{workingPath}
+	}
+
+	var found bool
+	for _, journalPath := range journals {
+		if journalPath == workingPath {
+			// Convert ours last, as it's the newest.
+			found = true
+			continue
+		}
+		err := h.convertWithRetry(ctx, journalPath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.WithError(err).WithField("journalPath", journalPath).WithField("function", "convertStaged").Error("Unable to convert a journal left from an earlier rotation")
+		}
+	}
+	if !found {
+		// Nothing of ours to convert, or an earlier rotation
+		// has already converted it.
+		return nil
+	}
+	return h.convertWithRetry(ctx, workingPath)
+}
+
+// lockConversion waits until no other conversion is under way, and
+// stops anything else from converting until unlockConversion is
+// called.  Should ctx end first, ctx's error is returned and nothing
+// is locked.
+func (h *RotatingHandler) lockConversion(ctx context.Context) error {
+	select {
+	case h.converting <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// unlockConversion lets the next conversion go ahead.
+func (h *RotatingHandler) unlockConversion() {
+	<-h.converting
+}
+
+// stagedJournals returns the paths of the journals in the staging
+// directory on fsys, oldest first.
+func stagedJournals(fsys FS, stagingDir string) ([]string, error) {
+	paths, err := listJournalDirs(fsys, stagingDir)
+	if err != nil {
+		return nil, err
+	}
+	var journals []string
+	modTimes := make(map[string]time.Time)
+	for _, p := range paths {
+		info, err := fsys.Stat(p)
+		if err != nil {
+			// Still being staged, or already gone.
+			continue
+		}
+		journals = append(journals, p)
+		modTimes[p] = info.ModTime()
+	}
+	sort.SliceStable(journals, func(i, j int) bool {
+		return modTimes[journals[i]].Before(modTimes[journals[j]])
+	})
+	return journals, nil
+}
+
+// convertWithRetry converts and archives a rotated journal, retrying
+// with exponential backoff should that fail.  Once the attempts are
+// exhausted the journal is quarantined, unless the handler has been
+// told to always remove temporary files, in which case it is thrown
+// away.  Should ctx end, the journal is left in place, to be converted
+// by a later rotation, and ctx's error is returned.  The caller must
+// hold the conversion lock.
+func (h *RotatingHandler) convertWithRetry(ctx context.Context, journalPath string) error {
+	h.mu.Lock()
+	attempts, backoff, quarantineDir := h.retryAttempts, h.retryBackoff, h.quarantineDir
+	h.mu.Unlock()
+
+	var failures []string
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = h.convertToORC(ctx, journalPath)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		failures = append(failures, err.Error())
+		if attempt >= attempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+
+	logCtx := log.WithFields(
+		log.Fields{
+			"journalPath": journalPath,
+			"function":    "convertWithRetry",
+		})
+	if h.alwaysRemoveTempFiles {
+		rerr := h.fs.RemoveAll(filepath.Dir(journalPath))
+		if rerr != nil {
+			logCtx.WithError(rerr).Error("Unable to remove temporary journal")
+		}
+		return err
+	}
+	qerr := quarantineJournal(h.fs, journalPath, quarantineDir, h.path, failures)
+	if qerr != nil {
+		logCtx.WithError(qerr).Error("Unable to quarantine journal")
+	} else {
+		logCtx.WithError(err).Error("Quarantined journal after repeated conversion failures")
+	}
+	return err
+}
+
+// Rotate is RotateContext with a context that never ends.
+func (h *RotatingHandler) Rotate() error {
+	return h.RotateContext(context.Background())
+}
+
+// RotateContext is a blocking call and will not return until an ORC file has
 // been created.  Logging will only be blocked for the earliest part
 // of the process, but subsequent calls to Rotate will not complete
 // until earlier ones have already completed.
 //
-// The caller should check any returned error using
-// IsCriticalRotationError.  If a CriticalRotationError is returned,
-// logging will no longer work as the handler will not be unlocked.
-// It is the callers responsiblity to decide on a course of action at
-// that point (when all else fails, panic).
-func (h *RotatingHandler) Rotate() error {
+// Should rotation fail, the handler carries on logging to its current
+// journal, Healthy will return false, and Rotate can simply be called
+// again to retry.  Only if no journal can be opened at all is a
+// CriticalRotationError returned; the caller should check for this
+// using IsCriticalRotationError.  Entries logged in that state are
+// rejected with an error until a journal can be reopened, and it is
+// the callers responsiblity to decide on a course of action (when all
+// else fails, panic).
+//
+// Should ctx end while waiting for an earlier conversion to finish, or
+// while the journal is being converted, conversion is abandoned and
+// ctx's error returned.  No partial ORC file is
+// archived; the rotated journal is kept in the staging directory, and
+// converted by the next call to Rotate or RotateContext, or by Close.
+// Logging carries on to the new journal regardless.
+func (h *RotatingHandler) RotateContext(ctx context.Context) error {
+	if h.direct {
+		return h.rotateDirect()
+	}
+
 	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return ErrHandlerClosed
+	}
+	workingPath, err := h.stageJournal()
+	h.mu.Unlock()
+	if workingPath == "" {
+		return err
+	}
+	// At this point logging can continue
+	cerr := h.convertStaged(ctx, workingPath)
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// stageJournal closes the current journal, moves it into a fresh
+// subdirectory of the staging directory and opens a new journal in
+// its place, returning the staged path.  The caller must hold h.mu.
+//
+// A staged path may be returned along with an error, in which case
+// the journal was moved successfully but no new one could be opened;
+// the staged journal should still be converted.
+func (h *RotatingHandler) stageJournal() (string, error) {
+	if h.handler == nil {
+		// A previous failure left us without a journal.
+		handler, err := openJournalHandlerForPath(h.fs, h.journalPath, h.keys)
+		if err != nil {
+			return "", CriticalRotationError{err}
+		}
+		h.handler = handler
+	}
+
 	err := h.handler.Close()
 	if err != nil {
-		return CriticalRotationError{err}
+		return "", h.reopenJournal(err)
 	}
-	dir, err := ioutil.TempDir("", "avocet-journal-")
+	workingPath, err := h.moveJournal()
 	if err != nil {
-		return CriticalRotationError{err}
+		return "", h.reopenJournal(err)
 	}
-	workingPath := path.Join(dir, "working.jrnl")
-	err = os.Rename(h.journalPath, workingPath)
+
+	handler, err := newJournalHandlerForPath(h.fs, h.journalPath, h.keys)
 	if err != nil {
-		return CriticalRotationError{err}
+		return workingPath, h.reopenJournal(err)
 	}
+	h.handler = handler
+	h.rotateErr = nil
+	return workingPath, nil
+}
 
-	h.handler, err = newJournalHandlerForPath(h.journalPath)
+// moveJournal moves the closed journal into a fresh subdirectory of
+// the staging directory, returning its new path.  The caller must
+// hold h.mu.
+func (h *RotatingHandler) moveJournal() (string, error) {
+	err := h.fs.MkdirAll(h.stagingDir, 0700)
 	if err != nil {
-		return CriticalRotationError{err}
+		return "", err
 	}
-	h.mu.Unlock()
-	// At this point logging can continue
-	return h.convertToORC(workingPath, h.path)
+	dir, err := tempDir(h.fs, h.stagingDir, journalDirPrefix)
+	if err != nil {
+		return "", err
+	}
+	info := rotationInfo{
+		Journal:   path.Base(h.journalPath),
+		RotatedAt: time.Now(),
+		StagingID: path.Base(dir),
+	}
+	err = writeRotationInfo(h.fs, dir, info)
+	if err != nil {
+		h.fs.RemoveAll(dir)
+		return "", err
+	}
+	workingPath := path.Join(dir, workingJournalName)
+	err = moveFile(h.fs, h.journalPath, workingPath)
+	if err != nil {
+		h.fs.RemoveAll(dir)
+		return "", err
+	}
+	return workingPath, nil
+}
+
+// stageLeftoverJournal stages any journal left behind by a
+// RotatingHandler that wasn't closed, as when its process crashed, so
+// that it is converted by the next rotation rather than truncated.  As
+// we hold the lock on the log, the journal can only be this log's.
+func (h *RotatingHandler) stageLeftoverJournal() error {
+	info, err := h.fs.Stat(h.journalPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("apexorc: journal %s is a directory", h.journalPath)
+	}
+	empty, err := journalEmpty(h.fs, h.journalPath, h.keys)
+	if err != nil || empty {
+		return err
+	}
+	_, err = h.moveJournal()
+	return err
+}
+
+// rotationInfo records where a staged journal came from, and is
+// copied into the manifest of each file it is converted to.  Journal
+// is the name the journal was written under, RotatedAt when it was
+// moved aside, and StagingID the name of the directory it was first
+// staged in, which it keeps if it is quarantined.
+type rotationInfo struct {
+	Journal   string    `json:"journal"`
+	RotatedAt time.Time `json:"rotated_at"`
+	StagingID string    `json:"staging_id"`
+}
+
+// writeRotationInfo records info in dir, the directory of a staged
+// journal.
+func writeRotationInfo(fsys FS, dir string, info rotationInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return writeFile(fsys, path.Join(dir, rotationInfoName), b, 0600)
+}
+
+// readRotationInfo reads what is recorded of where the staged journal
+// at journalPath came from.  A journal with no record, or one that
+// can't be read, is taken to have been staged in the directory it is
+// in, and Journal is left empty.
+func readRotationInfo(fsys FS, journalPath string) rotationInfo {
+	dir := filepath.Dir(journalPath)
+	var info rotationInfo
+	b, err := readFile(fsys, filepath.Join(dir, rotationInfoName))
+	if err != nil || json.Unmarshal(b, &info) != nil {
+		info = rotationInfo{}
+	}
+	if info.StagingID == "" {
+		info.StagingID = filepath.Base(dir)
+	}
+	return info
+}
+
+// journalOrigin returns where the staged journal at journalPath came
+// from.  One staged without a record of it is assumed to have been
+// this handler's, rotated when it was last written to.
+func (h *RotatingHandler) journalOrigin(journalPath string) rotationInfo {
+	info := readRotationInfo(h.fs, journalPath)
+	if info.Journal != "" {
+		return info
+	}
+	info.Journal = filepath.Base(h.journalPath)
+	if fi, err := h.fs.Stat(journalPath); err == nil {
+		info.RotatedAt = fi.ModTime()
+	}
+	return info
+}
+
+// reopenJournal records that rotation failed with err and tries to
+// get logging going again by appending to whatever journal is at
+// h.journalPath.  It returns err, or a CriticalRotationError if no
+// journal could be opened.  The caller must hold h.mu.
+func (h *RotatingHandler) reopenJournal(err error) error {
+	h.rotateErr = err
+	handler, oerr := openJournalHandlerForPath(h.fs, h.journalPath, h.keys)
+	if oerr != nil {
+		h.handler = nil
+		return CriticalRotationError{oerr}
+	}
+	h.handler = handler
+	return err
+}
+
+// Healthy returns false if the most recent rotation failed, or if the
+// handler has no journal to write entries to.  A successful call to
+// Rotate restores it to health.
+func (h *RotatingHandler) Healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.handler != nil && h.rotateErr == nil
+}
+
+// rotateDirect finalises the ORC file written by a RotatingHandler
+// created with the DirectORC option and hands it to the ArchiveFunc.
+// The subordinate Handler opens a fresh file when the next entry
+// arrives.
+func (h *RotatingHandler) rotateDirect() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return ErrHandlerClosed
+	}
+	// The handlers will open new files for the next entry even if
+	// this fails, so failures here never stop logging.  A file that
+	// couldn't be finalised or published is set aside, alongside the
+	// log, rather than lost.
+	outputs := h.handler.(fanOutHandler)
+	h.rotateErr = outputs.Close()
+	if h.rotateErr == nil {
+		h.rotateErr = h.archiveOutputs(outputs, rotationInfo{}, "")
+	}
+	return h.rotateErr
 }
 
 // NumericArchiveF is an ArchiveFunc that archives historic log files
 // with numeric suffixes.  The lower the suffix the more recent the
 // file.  Older archived files are pushed back to higher-number
-// suffixes as the new archives are created.
+// suffixes as the new archives are created.  Manifests are moved along
+// with the files they describe.
 func NumericArchiveF(oldPath string) error {
+	return numericArchive(OSFS{}, oldPath)
+}
+
+// NumericArchiveFunc returns an ArchiveFunc that works like
+// NumericArchiveF, but on fsys.
+func NumericArchiveFunc(fsys FS) ArchiveFunc {
+	return func(oldPath string) error {
+		return numericArchive(fsys, oldPath)
+	}
+}
+
+func numericArchive(fsys FS, oldPath string) error {
 	var newPath string
 
 	dir, fileName := path.Split(oldPath)
 	extension := path.Ext(fileName)
 	prefix := fileName[:len(fileName)-len(extension)]
 
-	if extension == ".orc" {
+	counter, err := strconv.Atoi(strings.TrimPrefix(extension, "."))
+	if err != nil {
+		// It's the live file, rather than an archive, so it
+		// becomes the first archive.
 		newPath = oldPath + ".1"
 	} else {
-		counter, err := strconv.Atoi(extension[1:])
-		if err != nil {
-			return err
-		}
 		newPath = fmt.Sprintf("%s.%d", filepath.Join(dir, prefix), counter+1)
 	}
 
 	// If the new path doesn't exist, we'll move the old file there and be done!
-	_, err := os.Stat(newPath)
+	_, err = fsys.Stat(newPath)
 	if err == nil || !os.IsNotExist(err) {
 		// This block should recursively move all existing logs back one number
-		err = NumericArchiveF(newPath)
+		err = numericArchive(fsys, newPath)
 		if err != nil {
 			return err
 		}
 	}
 
-	return os.Rename(oldPath, newPath)
-
+	err = fsys.Rename(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	// Keep any manifest with the file it describes.
+	err = fsys.Rename(ManifestPath(oldPath), ManifestPath(newPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
 }
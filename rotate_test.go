@@ -1,11 +1,14 @@
 package apexorc
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/scritchley/orc"
@@ -147,4 +150,282 @@ func TestRotateAndConvertToORC(t *testing.T) {
 
 	f.Close()
 
+	// The rotated journal should have been cleaned up.
+	staged, err := ioutil.ReadDir(rotator.stagingDir)
+	if err != nil {
+		t.Fatalf("Error reading staging directory: %s", err)
+	}
+	if len(staged) != 0 {
+		t.Errorf("Expected an empty staging directory, found %d entries", len(staged))
+	}
+}
+
+// Rotated journals are staged in the directory set by SetStagingDir.
+func TestRotateWithStagingDir(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-rotate-staging")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	stagingDir := filepath.Join(tmpdir, "staging", "nested")
+	rotator, err := NewRotatingHandler(path, NumericArchiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	rotator.SetStagingDir(stagingDir)
+	log.SetHandler(rotator)
+	log.Info("Staged")
+
+	err = rotator.Rotate()
+	if err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("Error converting to ORC: %s", err)
+	}
+	staged, err := ioutil.ReadDir(stagingDir)
+	if err != nil {
+		t.Fatalf("Error reading staging directory: %s", err)
+	}
+	if len(staged) != 0 {
+		t.Errorf("Expected an empty staging directory, found %d entries", len(staged))
+	}
+	if _, err := os.Stat(makeStagingDirFromPath(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected the default staging directory not to be used")
+	}
+}
+
+// A RotatingHandler created with the DirectORC option writes straight
+// to an ORC file, which is finalised and archived when Rotate is
+// called.
+func TestRotateDirectORC(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-rotate-direct")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, NumericArchiveF, DirectORC())
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	if _, err := os.Stat(rotator.journalPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no journal at %q in direct mode", rotator.journalPath)
+	}
+
+	// Rotating before anything is logged has nothing to archive.
+	err = rotator.Rotate()
+	if err != nil {
+		t.Fatalf("Error rotating empty handler: %s", err)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Fatalf("Expected no archive after rotating an empty handler")
+	}
+
+	log.SetHandler(rotator)
+	log.Info("Direct 1")
+	err = rotator.Rotate()
+	if err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	log.Info("Direct 2")
+	err = rotator.Rotate()
+	if err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+
+	for suffix, logMsg := range map[string]string{".1": "Direct 2", ".2": "Direct 1"} {
+		f, err := orc.Open(path + suffix)
+		if err != nil {
+			t.Fatalf("Error opening ORC file: %s", err)
+		}
+		cursor := f.Select("message")
+		if !cursor.Stripes() {
+			t.Fatalf("No stripes in ORC file")
+		}
+		if !cursor.Next() {
+			t.Fatal("Cursor.Next() returned false, expected true")
+		}
+		msg, _ := cursor.Row()[0].(string)
+		if msg != logMsg {
+			t.Errorf("Expected %q in %s, got %q", logMsg, suffix, msg)
+		}
+		f.Close()
+	}
+}
+
+// A failed rotation must leave the handler logging to its existing
+// journal, so that a later Rotate can archive everything.
+func TestRotateRecoversFromFailure(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "avct-apexorc-test-rotate-recover")
+	if err != nil {
+		t.Fatalf("Error from ioutil.TempDir: %s", err.Error())
+	}
+	defer os.RemoveAll(tmpdir)
+
+	path := filepath.Join(tmpdir, "testlog.orc")
+	rotator, err := NewRotatingHandler(path, NumericArchiveF)
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	// A staging directory beneath a regular file can never be
+	// created.
+	blocker := filepath.Join(tmpdir, "blocker")
+	err = ioutil.WriteFile(blocker, nil, 0600)
+	if err != nil {
+		t.Fatalf("Error creating tempfile: %s", err.Error())
+	}
+	rotator.SetStagingDir(filepath.Join(blocker, "staging"))
+
+	log.SetHandler(rotator)
+	log.Info("Before failure")
+	err = rotator.Rotate()
+	if err == nil {
+		t.Fatal("Expected an error rotating with an unusable staging directory")
+	}
+	if IsCriticalRotationError(err) {
+		t.Fatalf("Expected a recoverable error, got %s", err)
+	}
+	if rotator.Healthy() {
+		t.Error("Expected the handler to be unhealthy after a failed rotation")
+	}
+
+	// This would deadlock if Rotate had kept hold of the lock.
+	log.Info("After failure")
+
+	rotator.SetStagingDir(filepath.Join(tmpdir, "staging"))
+	err = rotator.Rotate()
+	if err != nil {
+		t.Fatalf("Error retrying rotation: %s", err)
+	}
+	if !rotator.Healthy() {
+		t.Error("Expected the handler to be healthy after a successful rotation")
+	}
+
+	f, err := orc.Open(path + ".1")
+	if err != nil {
+		t.Fatalf("Error opening ORC file: %s", err)
+	}
+	defer f.Close()
+	cursor := f.Select("message")
+	var msgs []string
+	for cursor.Stripes() {
+		for cursor.Next() {
+			msg, _ := cursor.Row()[0].(string)
+			msgs = append(msgs, msg)
+		}
+	}
+	expected := []string{"Before failure", "After failure"}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+}
+
+func testArchiveMessages(t *testing.T, fsys FS, orcPath string) []string {
+	var msgs []string
+	err := replayArchive(fsys, orcPath, nil, func(e *log.Entry) error {
+		msgs = append(msgs, e.Message)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading %s: %s", orcPath, err)
+	}
+	return msgs
+}
+
+// RotateContext gives up waiting for a conversion that is already under
+// way once ctx ends, leaving its journal staged for the next rotation.
+func TestRotateContextWaiting(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	archiving := make(chan struct{})
+	release := make(chan struct{})
+	archiveF := func(oldPath string) error {
+		select {
+		case archiving <- struct{}{}:
+			<-release
+		default:
+		}
+		return numericArchive(fsys, oldPath)
+	}
+	rotator, err := NewRotatingHandler(path, archiveF, WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	testLogChunkEntries(t, rotator, []string{"First"})
+	done := make(chan error)
+	go func() {
+		done <- rotator.Rotate()
+	}()
+	<-archiving
+
+	testLogChunkEntries(t, rotator, []string{"Second"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err = rotator.RotateContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	close(release)
+	if err = <-done; err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	expected := []string{"First", "Second"}
+	if msgs, _ := testArchivedMessages(t, fsys, path); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q, got %q", expected, msgs)
+	}
+}
+
+// Cancelling RotateContext abandons conversion without archiving a
+// partial file, and the next rotation converts the journal it left.
+func TestRotateContextCancelled(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	if err = rotator.HandleLog(makeTestEntry("Before", nil, nil)); err != nil {
+		t.Fatalf("Error logging: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = rotator.RotateContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := fsys.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be archived, got %v", err)
+	}
+	if _, err := fsys.Stat(makeTempPathFromPath(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected the partial ORC file to be removed, got %v", err)
+	}
+	staged, _ := listJournalDirs(fsys, rotator.stagingDir)
+	if len(staged) != 1 {
+		t.Fatalf("Expected the journal to be left staged, found %v", staged)
+	}
+
+	// Logging carries on, and the next rotation catches up.
+	if err = rotator.HandleLog(makeTestEntry("After", nil, nil)); err != nil {
+		t.Fatalf("Error logging: %s", err)
+	}
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	if msgs := testArchiveMessages(t, fsys, path+".2"); !reflect.DeepEqual(msgs, []string{"Before"}) {
+		t.Errorf("Expected the older archive to hold [Before], got %q", msgs)
+	}
+	if msgs := testArchiveMessages(t, fsys, path+".1"); !reflect.DeepEqual(msgs, []string{"After"}) {
+		t.Errorf("Expected the newer archive to hold [After], got %q", msgs)
+	}
+	if staged, _ := listJournalDirs(fsys, rotator.stagingDir); len(staged) != 0 {
+		t.Errorf("Expected no staged journals, found %v", staged)
+	}
 }
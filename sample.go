@@ -0,0 +1,224 @@
+package apexorc
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// SuppressedField is the field that holds, in a summary row written
+// by a SamplingHandler, how many entries with the row's level and
+// message were suppressed.
+const SuppressedField = "suppressed"
+
+// defaultSummaryInterval is how often a SamplingHandler writes
+// summary rows, and resets its per-message counts, by default.
+const defaultSummaryInterval = time.Minute
+
+// SamplingHandler complies with the github.com/apex/log.Handler
+// interface, passing only a sample of the entries it receives on to
+// another handler, typically a RotatingHandler, so that hot loops
+// can't flood the log.  Three kinds of limit can be set, and an entry
+// is only passed on if it passes all of them:
+//
+//   - SetLevelRate keeps a random fraction of the entries at a level.
+//   - SetFirstThenEvery keeps the first N entries with each message,
+//     then every Mth.
+//   - SetRateLimit keeps entries at no more than a steady rate, with
+//     bursts, using a token bucket.
+//
+// Entries that are dropped are counted by level and message.  Once
+// every summary interval, or when Flush is called, a summary row is
+// passed on for each level and message that had entries suppressed:
+// an entry with that level and message, timestamped when the summary
+// was made, whose SuppressedField holds the count.  The per-message
+// counts of SetFirstThenEvery start again after each summary.
+type SamplingHandler struct {
+	mu       sync.Mutex
+	next     log.Handler
+	rates    map[log.Level]float64
+	first    int
+	every    int
+	limit    float64 // limit is the rate limit, in entries per second, or zero for none.
+	burst    float64
+	tokens   float64
+	refilled time.Time
+	interval time.Duration
+	summary  time.Time // summary is when the last summary was made.
+
+	seen       map[sampleKey]int
+	suppressed map[sampleKey]int64
+	total      int64
+
+	now    func() time.Time
+	random func() float64
+}
+
+// sampleKey identifies the entries that are counted together.
+type sampleKey struct {
+	level   log.Level
+	message string
+}
+
+// NewSamplingHandler returns a SamplingHandler passing entries on to
+// next.  Until limits are set every entry is passed on.
+func NewSamplingHandler(next log.Handler) *SamplingHandler {
+	return &SamplingHandler{
+		next:       next,
+		rates:      make(map[log.Level]float64),
+		interval:   defaultSummaryInterval,
+		seen:       make(map[sampleKey]int),
+		suppressed: make(map[sampleKey]int64),
+		now:        time.Now,
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())).Float64,
+	}
+}
+
+// SetLevelRate sets the fraction, between 0 and 1, of entries at
+// level that are kept.
+func (h *SamplingHandler) SetLevelRate(level log.Level, rate float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.rates[level] = rate
+}
+
+// SetFirstThenEvery keeps the first entries with each message in
+// each summary interval, and after that only every one in every.  An
+// every of zero keeps none after the first.
+func (h *SamplingHandler) SetFirstThenEvery(first, every int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.first = first
+	h.every = every
+}
+
+// SetRateLimit keeps no more than perSecond entries a second, on
+// average, allowing bursts of up to burst entries.  A perSecond of
+// zero removes the limit.
+func (h *SamplingHandler) SetRateLimit(perSecond float64, burst int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if burst < 1 {
+		burst = 1
+	}
+	h.limit = perSecond
+	h.burst = float64(burst)
+	h.tokens = h.burst
+	h.refilled = h.now()
+}
+
+// SetSummaryInterval sets how often summary rows are written.  An
+// interval of zero leaves it to calls to Flush.
+func (h *SamplingHandler) SetSummaryInterval(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.interval = d
+}
+
+// Suppressed returns the total number of entries suppressed so far.
+func (h *SamplingHandler) Suppressed() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.total
+}
+
+// HandleLog passes e on to the next handler, unless it is suppressed
+// by one of the limits.
+func (h *SamplingHandler) HandleLog(e *log.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	var err error
+	if h.interval > 0 && now.Sub(h.summary) >= h.interval {
+		if !h.summary.IsZero() {
+			err = h.flush(now)
+		}
+		h.summary = now
+	}
+
+	key := sampleKey{e.Level, e.Message}
+	if !h.keep(key, now) {
+		h.suppressed[key]++
+		h.total++
+		return err
+	}
+	if herr := h.next.HandleLog(e); err == nil {
+		err = herr
+	}
+	return err
+}
+
+// keep decides whether an entry is passed on.
+func (h *SamplingHandler) keep(key sampleKey, now time.Time) bool {
+	if rate, ok := h.rates[key.level]; ok && h.random() >= rate {
+		return false
+	}
+	if h.first > 0 || h.every > 0 {
+		n := h.seen[key]
+		h.seen[key] = n + 1
+		if n >= h.first && (h.every <= 0 || (n-h.first+1)%h.every != 0) {
+			return false
+		}
+	}
+	if h.limit > 0 {
+		h.tokens += now.Sub(h.refilled).Seconds() * h.limit
+		if h.tokens > h.burst {
+			h.tokens = h.burst
+		}
+		h.refilled = now
+		if h.tokens < 1 {
+			return false
+		}
+		h.tokens--
+	}
+	return true
+}
+
+// Flush passes a summary row for each level and message that has had
+// entries suppressed since the last summary on to the next handler,
+// and starts counting again.  Call it before rotating, or closing,
+// the next handler so the summary lands in the same file as the
+// entries it summarises.
+func (h *SamplingHandler) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	h.summary = now
+	return h.flush(now)
+}
+
+func (h *SamplingHandler) flush(now time.Time) error {
+	keys := make([]sampleKey, 0, len(h.suppressed))
+	for key := range h.suppressed {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].level != keys[j].level {
+			return keys[i].level < keys[j].level
+		}
+		return keys[i].message < keys[j].message
+	})
+
+	var err error
+	for _, key := range keys {
+		herr := h.next.HandleLog(&log.Entry{
+			Timestamp: now,
+			Level:     key.level,
+			Message:   key.message,
+			Fields: log.Fields{
+				SuppressedField: strconv.FormatInt(h.suppressed[key], 10),
+			},
+		})
+		if err == nil {
+			err = herr
+		}
+	}
+	h.suppressed = make(map[sampleKey]int64)
+	h.seen = make(map[sampleKey]int)
+	return err
+}
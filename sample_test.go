@@ -0,0 +1,98 @@
+package apexorc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+func TestSamplingFirstThenEvery(t *testing.T) {
+	rec := &entryRecorder{}
+	h := NewSamplingHandler(rec)
+	h.SetSummaryInterval(0)
+	h.SetFirstThenEvery(2, 3)
+
+	for i := 0; i < 10; i++ {
+		h.HandleLog(&log.Entry{Level: log.DebugLevel, Message: "Hot loop", Fields: log.Fields{"i": i}})
+	}
+	h.HandleLog(&log.Entry{Level: log.DebugLevel, Message: "Elsewhere", Fields: log.Fields{"i": 0}})
+	var kept []interface{}
+	for _, e := range rec.entries {
+		kept = append(kept, e.Fields["i"])
+	}
+	expected := []interface{}{0, 1, 4, 7, 0}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf("Expected %v to be kept, got %v", expected, kept)
+	}
+	if n := h.Suppressed(); n != 6 {
+		t.Errorf("Expected 6 suppressed, got %d", n)
+	}
+
+	rec.entries = nil
+	if err := h.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if len(rec.entries) != 1 {
+		t.Fatalf("Expected one summary row, got %d", len(rec.entries))
+	}
+	summary := rec.entries[0]
+	if summary.Message != "Hot loop" || summary.Level != log.DebugLevel || summary.Fields[SuppressedField] != "6" {
+		t.Errorf("Unexpected summary row %+v", summary)
+	}
+}
+
+func TestSamplingLevelRate(t *testing.T) {
+	rec := &entryRecorder{}
+	h := NewSamplingHandler(rec)
+	h.SetLevelRate(log.DebugLevel, 0.25)
+	samples := []float64{0.1, 0.3, 0.2, 0.9}
+	h.random = func() float64 {
+		r := samples[0]
+		samples = samples[1:]
+		return r
+	}
+	for i := 0; i < 4; i++ {
+		h.HandleLog(&log.Entry{Level: log.DebugLevel, Message: "Sampled"})
+	}
+	h.HandleLog(&log.Entry{Level: log.InfoLevel, Message: "Unsampled"})
+	if len(rec.entries) != 3 {
+		t.Errorf("Expected 3 entries to be kept, got %d", len(rec.entries))
+	}
+}
+
+func TestSamplingRateLimit(t *testing.T) {
+	rec := &entryRecorder{}
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	h := NewSamplingHandler(rec)
+	h.now = func() time.Time { return now }
+	h.SetSummaryInterval(time.Minute)
+	h.SetRateLimit(2, 3)
+
+	for i := 0; i < 5; i++ {
+		h.HandleLog(&log.Entry{Level: log.InfoLevel, Message: "Burst", Timestamp: now})
+	}
+	if len(rec.entries) != 3 {
+		t.Fatalf("Expected a burst of 3, got %d", len(rec.entries))
+	}
+	now = now.Add(time.Second)
+	for i := 0; i < 5; i++ {
+		h.HandleLog(&log.Entry{Level: log.InfoLevel, Message: "Steady", Timestamp: now})
+	}
+	if len(rec.entries) != 5 {
+		t.Fatalf("Expected 2 more after a second, got %d", len(rec.entries)-3)
+	}
+
+	// The summary is written by the first entry after the interval.
+	now = now.Add(time.Minute)
+	h.HandleLog(&log.Entry{Level: log.InfoLevel, Message: "Later", Timestamp: now})
+	var msgs []string
+	for _, e := range rec.entries[5:7] {
+		msgs = append(msgs, e.Message+"/"+e.Fields.Get(SuppressedField).(string))
+	}
+	expected := []string{"Burst/2", "Steady/3"}
+	if !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected summaries %q, got %q", expected, msgs)
+	}
+}
@@ -0,0 +1,158 @@
+package apexorc
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// SlogSourceField is the field that holds the file and line of the
+// call that logged an entry, when slog.HandlerOptions.AddSource is
+// set.
+const SlogSourceField = "source"
+
+// SlogHandler is a log/slog.Handler that passes each record on as a
+// log.Entry to a github.com/apex/log.Handler, typically a
+// RotatingHandler, so code using the standard library's structured
+// logging writes the same journals and ORC files as code using apex.
+//
+// Attributes become fields.  Groups are flattened, with each group's
+// name joined to the keys within it by a dot, so a "method" attribute
+// in a "request" group becomes the field "request.method".  Values
+// keep their types: strings, int64s, uint64s, float64s, bools,
+// time.Durations and time.Times are stored along with their types, so
+// they are read back as they were logged, and anything else is stored
+// as JSON, or by its Error or String method if it has one.
+//
+// slog levels are mapped to the apex level at or below them, so
+// slog.LevelDebug is DebugLevel, and anything above slog.LevelError
+// is ErrorLevel.  ReplaceAttr, if set, is applied to attributes but
+// not to the time, level and message, which have columns of their
+// own.
+type SlogHandler struct {
+	handler log.Handler
+	opts    slog.HandlerOptions
+	groups  []string   // groups are the groups opened by WithGroup.
+	fields  log.Fields // fields are the attributes added by WithAttrs.
+}
+
+// NewSlogHandler returns a SlogHandler passing records on to handler.
+// opts may be nil, in which case records at slog.LevelInfo and above
+// are handled.
+func NewSlogHandler(handler log.Handler, opts *slog.HandlerOptions) *SlogHandler {
+	h := &SlogHandler{handler: handler, fields: log.Fields{}}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+// Enabled reports whether records at level are handled.
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	min := slog.LevelInfo
+	if h.opts.Level != nil {
+		min = h.opts.Level.Level()
+	}
+	return level >= min
+}
+
+// Handle passes r on as a log.Entry.
+func (h *SlogHandler) Handle(_ context.Context, r slog.Record) error {
+	e := &log.Entry{
+		Timestamp: r.Time,
+		Level:     apexLevel(r.Level),
+		Message:   r.Message,
+		Fields:    make(log.Fields, len(h.fields)+r.NumAttrs()),
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	for k, v := range h.fields {
+		e.Fields[k] = v
+	}
+	if h.opts.AddSource && r.PC != 0 {
+		frames := runtime.CallersFrames([]uintptr{r.PC})
+		frame, _ := frames.Next()
+		e.Fields[SlogSourceField] = frame.File + ":" + strconv.Itoa(frame.Line)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		h.addAttr(e.Fields, h.groups, a)
+		return true
+	})
+	return h.handler.HandleLog(e)
+}
+
+// WithAttrs returns a SlogHandler that adds attrs to every record.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := h.clone()
+	for _, a := range attrs {
+		h.addAttr(c.fields, c.groups, a)
+	}
+	return c
+}
+
+// WithGroup returns a SlogHandler that puts every attribute added
+// after it in the named group.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := h.clone()
+	c.groups = append(c.groups[:len(c.groups):len(c.groups)], name)
+	return c
+}
+
+func (h *SlogHandler) clone() *SlogHandler {
+	c := *h
+	c.fields = make(log.Fields, len(h.fields))
+	for k, v := range h.fields {
+		c.fields[k] = v
+	}
+	return &c
+}
+
+// addAttr adds a, which is within groups, to fields, flattening any
+// group it holds.
+func (h *SlogHandler) addAttr(fields log.Fields, groups []string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() != slog.KindGroup && h.opts.ReplaceAttr != nil {
+		a = h.opts.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		inner := groups
+		if a.Key != "" {
+			// Groups with no name are inlined.
+			inner = append(groups[:len(groups):len(groups)], a.Key)
+		}
+		for _, ga := range a.Value.Group() {
+			h.addAttr(fields, inner, ga)
+		}
+		return
+	}
+	key := a.Key
+	for i := len(groups) - 1; i >= 0; i-- {
+		key = groups[i] + "." + key
+	}
+	fields[key] = a.Value.Any()
+}
+
+// apexLevel maps a slog.Level to the apex level at or below it.
+func apexLevel(level slog.Level) log.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return log.DebugLevel
+	case level < slog.LevelWarn:
+		return log.InfoLevel
+	case level < slog.LevelError:
+		return log.WarnLevel
+	}
+	return log.ErrorLevel
+}
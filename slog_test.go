@@ -0,0 +1,140 @@
+package apexorc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+func TestSlogHandler(t *testing.T) {
+	rec := &entryRecorder{}
+	logger := slog.New(NewSlogHandler(rec, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	when := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	timeout := errors.New("timeout")
+
+	logger.With("service", "api").WithGroup("request").With("method", "GET").Warn("Slow",
+		"elapsed", 1500*time.Millisecond,
+		"status", 200,
+		"ok", true,
+		slog.Group("user", "id", 42, "since", when),
+		"err", timeout,
+		"tags", []string{"a", "b"},
+	)
+	logger.Debug("Quiet", slog.Group("", "inline", 1.5), slog.Group("empty"))
+
+	if len(rec.entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(rec.entries))
+	}
+	e := rec.entries[0]
+	if e.Level != log.WarnLevel || e.Message != "Slow" || e.Timestamp.IsZero() {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	expected := log.Fields{
+		"service":            "api",
+		"request.method":     "GET",
+		"request.elapsed":    1500 * time.Millisecond,
+		"request.status":     int64(200),
+		"request.ok":         true,
+		"request.user.id":    int64(42),
+		"request.user.since": when,
+		"request.err":        timeout,
+		"request.tags":       []string{"a", "b"},
+	}
+	if !reflect.DeepEqual(e.Fields, expected) {
+		t.Errorf("Expected fields %v, got %v", expected, e.Fields)
+	}
+	e = rec.entries[1]
+	if e.Level != log.DebugLevel || !reflect.DeepEqual(e.Fields, log.Fields{"inline": 1.5}) {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+}
+
+// Attributes are read back from the journal and the ORC file with the
+// types they were logged with.
+func TestSlogHandlerTypes(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	when := time.Date(2017, 6, 1, 12, 0, 0, 500, time.UTC)
+	slog.New(NewSlogHandler(rotator, nil)).Info("Typed",
+		"name", "bilbo",
+		"age", 111,
+		"big", uint64(1<<63),
+		"ratio", 0.25,
+		"ok", true,
+		"elapsed", 1500*time.Millisecond,
+		"since", when,
+		"err", errors.New("timeout"),
+		"tags", []string{"a", "b"},
+		"looks", "42",
+	)
+	expected := log.Fields{
+		"name":    "bilbo",
+		"age":     int64(111),
+		"big":     uint64(1 << 63),
+		"ratio":   0.25,
+		"ok":      true,
+		"elapsed": 1500 * time.Millisecond,
+		"since":   when,
+		"err":     "timeout",
+		"tags":    []interface{}{"a", "b"},
+		"looks":   "42",
+	}
+
+	set, err := rotator.OpenLogSet(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Error opening log set: %s", err)
+	}
+	if !set.Next() {
+		t.Fatalf("Expected an entry in the journal: %v", set.Err())
+	}
+	if fields := set.Entry().Fields; !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %#v from the journal, got %#v", expected, fields)
+	}
+	set.Close()
+
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	var entries []*log.Entry
+	err = replayArchive(fsys, path+".1", nil, func(e *log.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading archive: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(entries))
+	}
+	if fields := entries[0].Fields; !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected %#v from the archive, got %#v", expected, fields)
+	}
+}
+
+func TestSlogHandlerLevels(t *testing.T) {
+	h := NewSlogHandler(&entryRecorder{}, nil)
+	if h.Enabled(context.Background(), slog.LevelDebug) || !h.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("Expected the default level to be Info")
+	}
+	cases := map[slog.Level]log.Level{
+		slog.LevelDebug - 4: log.DebugLevel,
+		slog.LevelInfo:      log.InfoLevel,
+		slog.LevelInfo + 2:  log.InfoLevel,
+		slog.LevelWarn:      log.WarnLevel,
+		slog.LevelError + 4: log.ErrorLevel,
+	}
+	for in, expected := range cases {
+		if got := apexLevel(in); got != expected {
+			t.Errorf("%s: expected %s, got %s", in, expected, got)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package apexorc
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// VerifyConversions is an Option that makes the RotatingHandler check
+// each ORC file it converts a journal to before archiving it.  The
+// file is reopened and read back, and the number of rows and the
+// range of timestamps in it compared with the entries replayed from
+// the journal, including any that couldn't be written.  Only if they
+// match is the file archived and the journal removed.  Otherwise the
+// file is removed, and the conversion fails with a *VerificationError,
+// so the journal is kept and, like any other failure, retried and
+// eventually quarantined.
+//
+// Verification reads every file back in full, so roughly doubles the
+// cost of conversion.
+func VerifyConversions() Option {
+	return func(h *RotatingHandler) {
+		h.verify = true
+	}
+}
+
+// VerificationError describes an ORC file that didn't hold what was
+// written to it.
+type VerificationError struct {
+	Path string
+
+	ExpectedRows int64
+	ExpectedMin  time.Time
+	ExpectedMax  time.Time
+
+	Rows int64
+	Min  time.Time
+	Max  time.Time
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("apexorc: %s failed verification: expected %d rows from %s to %s, found %d rows from %s to %s",
+		e.Path, e.ExpectedRows, e.ExpectedMin.Format(time.RFC3339Nano), e.ExpectedMax.Format(time.RFC3339Nano),
+		e.Rows, e.Min.Format(time.RFC3339Nano), e.Max.Format(time.RFC3339Nano))
+}
+
+// verifyORC reads back the ORC file at path on fsys and checks that
+// it agrees with expected, the stats of the entries written to it.
+func verifyORC(fsys FS, path string, keys KeyProvider, expected entryStats) error {
+	found, err := scanORCStats(fsys, path, keys)
+	if err != nil {
+		return err
+	}
+	if found.rows == expected.rows && found.min.Equal(expected.min) && found.max.Equal(expected.max) {
+		return nil
+	}
+	return &VerificationError{
+		Path:         path,
+		ExpectedRows: expected.rows,
+		ExpectedMin:  expected.min,
+		ExpectedMax:  expected.max,
+		Rows:         found.rows,
+		Min:          found.min,
+		Max:          found.max,
+	}
+}
+
+// scanORCStats reads the ORC file at path on fsys, returning the stats
+// of the entries in it.  No file was created if nothing was written to
+// it, so a missing file holds no entries.
+func scanORCStats(fsys FS, path string, keys KeyProvider) (entryStats, error) {
+	var found entryStats
+	f, err := fsys.Open(path)
+	if os.IsNotExist(err) {
+		return found, nil
+	}
+	if err != nil {
+		return found, err
+	}
+	src, err := newORCSource(f, keys)
+	if err != nil {
+		f.Close()
+		return found, err
+	}
+	defer src.Close()
+
+	for {
+		e, err := src.next()
+		if err == io.EOF {
+			return found, nil
+		}
+		if err != nil {
+			return found, err
+		}
+		found.add(e)
+	}
+}
+
+// verifyOutputs checks each ORC file written by outputs against
+// expected, the stats of the entries replayed to them, rather than
+// those the handlers wrote, so an entry that couldn't be written fails
+// verification.  Should one fail, every file written by outputs is
+// removed so none of them are archived.
+func (h *RotatingHandler) verifyOutputs(outputs fanOutHandler, expected entryStats) error {
+	for _, out := range outputs {
+		if _, ok := out.(*Handler); !ok {
+			continue
+		}
+		err := verifyORC(h.fs, out.filePath(), h.keys, expected)
+		if err == nil {
+			continue
+		}
+		for _, out := range outputs {
+			h.fs.Remove(out.filePath())
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package apexorc
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// verifyORC accepts a file that holds what was written to it, and
+// describes any difference.
+func TestVerifyORC(t *testing.T) {
+	fsys := NewMemFS()
+	handler := NewHandler("/testlog.orc")
+	handler.SetFS(fsys)
+	for _, msg := range []string{"one", "two", "three"} {
+		if err := handler.HandleLog(makeTestEntry(msg, nil, nil)); err != nil {
+			t.Fatalf("Error logging: %s", err)
+		}
+	}
+	if err := handler.Close(); err != nil {
+		t.Fatalf("Error closing: %s", err)
+	}
+	stats := handler.entryStats()
+
+	if err := verifyORC(fsys, "/testlog.orc", nil, stats); err != nil {
+		t.Fatalf("Error verifying: %s", err)
+	}
+
+	wrong := stats
+	wrong.rows++
+	wrong.max = wrong.max.Add(time.Second)
+	err := verifyORC(fsys, "/testlog.orc", nil, wrong)
+	verr, ok := err.(*VerificationError)
+	if !ok {
+		t.Fatalf("Expected a VerificationError, got %v", err)
+	}
+	if verr.Rows != 3 || verr.ExpectedRows != 4 {
+		t.Errorf("Expected 3 rows found of 4, got %d of %d", verr.Rows, verr.ExpectedRows)
+	}
+	if !verr.Max.Equal(stats.max) || !verr.ExpectedMax.Equal(wrong.max) {
+		t.Errorf("Unexpected timestamps in %s", verr)
+	}
+
+	// No file is fine, if nothing was written.
+	if err := verifyORC(fsys, "/missing.orc", nil, entryStats{}); err != nil {
+		t.Errorf("Error verifying an empty conversion: %s", err)
+	}
+}
+
+// With VerifyConversions, a file that fails verification is neither
+// archived nor has its journal removed.
+func TestRotateVerifyConversions(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys), VerifyConversions())
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	rotator.SetConversionRetries(1, 0)
+
+	if err = rotator.HandleLog(makeTestEntry("Checked", nil, nil)); err != nil {
+		t.Fatalf("Error logging: %s", err)
+	}
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	if _, err := fsys.Stat(path + ".1"); err != nil {
+		t.Errorf("Expected a verified archive: %s", err)
+	}
+
+	// Corrupt what's written by truncating the ORC file as soon as
+	// it is renamed into place.
+	rotator.fs = &truncatingFS{MemFS: fsys, path: path}
+	if err = rotator.HandleLog(makeTestEntry("Mangled", nil, nil)); err != nil {
+		t.Fatalf("Error logging: %s", err)
+	}
+	err = rotator.Rotate()
+	if err == nil {
+		t.Fatal("Expected an error rotating")
+	}
+	if _, err := fsys.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing more to be archived, got %v", err)
+	}
+	if _, err := fsys.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the bad file to be removed, got %v", err)
+	}
+	quarantined, _ := listJournalDirs(fsys, rotator.quarantineDir)
+	if len(quarantined) != 1 {
+		t.Errorf("Expected the journal to be kept in quarantine, found %v", quarantined)
+	}
+}
+
+// An entry that couldn't be written fails verification even though
+// the file holds everything that was written to it.
+func TestRotateVerifyDroppedEntry(t *testing.T) {
+	fsys := NewMemFS()
+	path := "/testlog.orc"
+	rotator, err := NewRotatingHandler(path, NumericArchiveFunc(fsys), WithFS(fsys), VerifyConversions())
+	if err != nil {
+		t.Fatalf("Error creating rotating handler: %s", err)
+	}
+	rotator.SetConversionRetries(2, 0)
+	expected := []string{"Dropped", "Kept"}
+	testLogChunkEntries(t, rotator, expected)
+
+	// The ORC file can't be created for the first entry, so only
+	// the second is written by the first attempt.
+	rotator.fs = &failingCreateFS{MemFS: fsys, path: makeTempPathFromPath(path), failures: 1}
+	if err = rotator.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %s", err)
+	}
+	if msgs := testArchiveMessages(t, fsys, path+".1"); !reflect.DeepEqual(msgs, expected) {
+		t.Errorf("Expected %q to be archived, got %q", expected, msgs)
+	}
+	if _, err := fsys.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Errorf("Expected only one archive, got %v", err)
+	}
+}
+
+// failingCreateFS fails to create the file at path the first failures
+// times it is asked to.
+type failingCreateFS struct {
+	*MemFS
+	path     string
+	failures int
+}
+
+func (fsys *failingCreateFS) Create(name string) (File, error) {
+	if name == fsys.path && fsys.failures > 0 {
+		fsys.failures--
+		return nil, errors.New("Not today")
+	}
+	return fsys.MemFS.Create(name)
+}
+
+// truncatingFS empties the file at path whenever something is renamed
+// to it.
+type truncatingFS struct {
+	*MemFS
+	path string
+}
+
+func (fsys *truncatingFS) Rename(oldpath, newpath string) error {
+	err := fsys.MemFS.Rename(oldpath, newpath)
+	if err == nil && newpath == fsys.path {
+		f, err := fsys.MemFS.OpenAppend(newpath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return f.Truncate(0)
+	}
+	return err
+}
@@ -2,13 +2,42 @@ package apexorc
 
 import (
 	"io"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/scritchley/orc"
 )
 
 // entrySchema defines the columns of our ORC log file.
-const entrySchema = "struct<timestamp:timestamp,level:string,message:string,fields:map<string,string>>"
+const entrySchema = "struct<timestamp:timestamp,level:string,message:string,fields:map<string,string>,repeat_count:bigint,last_timestamp:timestamp,field_types:map<string,string>>"
+
+// entrySchemaVersion identifies entrySchema in a Manifest, and must
+// change whenever entrySchema does.  Version 2 added the repeat_count
+// and last_timestamp columns, and version 3 the field_types column.
+const entrySchemaVersion = 3
+
+// RepeatCountField and LastTimestampField are the fields of a
+// log.Entry that hold the repeat_count and last_timestamp columns,
+// which describe a row standing for several identical entries, as
+// written by a DedupHandler.  They are stored in their own columns
+// rather than with the other fields, and are only set on entries
+// read back from rows with a repeat_count greater than one.  Their
+// values are of types private to this package, so that fields of the
+// same names logged by anything else are stored as ordinary fields;
+// use RepeatOf to read them.
+const (
+	RepeatCountField   = "repeat_count"
+	LastTimestampField = "last_timestamp"
+)
+
+// repeatCount and repeatLast are the types of the RepeatCountField
+// and LastTimestampField values that mark an entry as standing for
+// several.  Only a DedupHandler, and the readers of the columns they
+// are stored in, set them.
+type (
+	repeatCount int64
+	repeatLast  struct{ time.Time }
+)
 
 // newWriter creates a new orc.Writer based on a provided io.Writer
 // and with the entrySchema already set.
@@ -24,16 +53,34 @@ func newWriter(w io.Writer) (*orc.Writer, error) {
 // writeRecord will write a single row of data to a provided
 // orc.Writer based on a provided log.Entry.
 func writeRecord(w *orc.Writer, e *log.Entry) error {
-	var strVal string
-	var ok bool
-	fields := make(map[string]string, len(e.Fields))
-	for k, v := range e.Fields {
-		strVal, ok = v.(string)
-		if !ok {
-			// Maybe we should log an error here?
-			continue
-		}
-		fields[k] = strVal
+	count, last := RepeatOf(e)
+	fields, types := formatFields(e.Fields, false)
+	return w.Write(e.Timestamp, e.Level.String(), e.Message, fields, count, last, types)
+}
+
+// RepeatOf returns how many identical entries e stands for, and the
+// timestamp of the last of them: the values of its repeat_count and
+// last_timestamp columns.  These are one and e's own timestamp, unless
+// it was collapsed by a DedupHandler or read back from a row standing
+// for several entries.
+func RepeatOf(e *log.Entry) (int64, time.Time) {
+	count, ok := e.Fields[RepeatCountField].(repeatCount)
+	if !ok || count < 1 {
+		return 1, e.Timestamp
+	}
+	last := e.Timestamp
+	if v, ok := e.Fields[LastTimestampField].(repeatLast); ok {
+		last = v.Time
+	}
+	return int64(count), last
+}
+
+// isRepeatMarker reports whether v is the value of a RepeatCountField
+// or LastTimestampField that marks an entry as standing for several.
+func isRepeatMarker(v interface{}) bool {
+	switch v.(type) {
+	case repeatCount, repeatLast:
+		return true
 	}
-	return w.Write(e.Timestamp, e.Level.String(), e.Message, fields)
+	return false
 }